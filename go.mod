@@ -3,6 +3,9 @@ module OneVM
 go 1.23
 
 require (
+	github.com/goccy/go-yaml v1.18.0
+	github.com/klauspost/compress v1.17.11
+	github.com/pelletier/go-toml/v2 v2.2.4
 	github.com/pkg/sftp v1.13.7
 	golang.org/x/crypto v0.32.0
 )
@@ -0,0 +1,364 @@
+package vm
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	archiveBackupsDir       = "backups"
+	maxArchiveMetadataBytes = 1 << 20
+)
+
+type BackupFilter struct {
+	Host  string
+	Path  string
+	Run   string
+	Since time.Time
+	Until time.Time
+}
+
+type BackupImport struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func (f BackupFilter) Match(b BackupInfo) bool {
+	if f.Host != "" && b.Host != f.Host {
+		return false
+	}
+	if f.Path != "" {
+		if ok, _ := path.Match(f.Path, b.Remote); !ok {
+			return false
+		}
+	}
+	if f.Run != "" && b.Run != f.Run {
+		return false
+	}
+	if !f.Since.IsZero() && b.Timestamp.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && b.Timestamp.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+func ExportBackups(cfg BackupConfig, filter BackupFilter, w io.Writer) ([]BackupInfo, error) {
+	backups, err := ListBackups(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	written := map[string]bool{}
+	var exported []BackupInfo
+
+	for _, b := range backups {
+		if !isBackupMetadata(b.Path) || !filter.Match(b) {
+			continue
+		}
+
+		meta, err := readBackupMetadata(b.Path)
+		if err != nil {
+			return nil, err
+		}
+		if meta.Object == "" {
+			continue
+		}
+		dir := filepath.Dir(b.Path)
+		objPath, err := objectPath(dir, meta.Object, meta.Compression, meta.Encrypted)
+		if err != nil {
+			return nil, err
+		}
+
+		rel, _ := filepath.Rel(dir, objPath)
+		if name := filepath.ToSlash(rel); !written[name] {
+			if err := addFileToArchive(tw, objPath, name); err != nil {
+				return nil, err
+			}
+			written[name] = true
+		}
+
+		name := path.Join(archiveBackupsDir, filepath.Base(b.Path))
+		if err := addFileToArchive(tw, b.Path, name); err != nil {
+			return nil, err
+		}
+		exported = append(exported, b)
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("writing archive: %w", err)
+	}
+	if err := gw.Close(); err != nil {
+		return nil, fmt.Errorf("writing archive: %w", err)
+	}
+
+	return exported, nil
+}
+
+func addFileToArchive(tw *tar.Writer, src, name string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", src, err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", src, err)
+	}
+
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("writing archive: %w", err)
+	}
+	if _, err := io.Copy(tw, f); err != nil {
+		return fmt.Errorf("writing archive: %w", err)
+	}
+	return nil
+}
+
+func ImportBackups(cfg BackupConfig, r io.Reader) ([]BackupImport, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading archive: %w", err)
+	}
+	defer gr.Close()
+
+	dir := cfg.LocalDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("creating backup directory: %w", err)
+	}
+
+	key, err := cfg.backupKey()
+	if err != nil {
+		return nil, err
+	}
+
+	var results []BackupImport
+
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading archive: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		dest, isBackup, err := archiveDestination(dir, hdr.Name)
+		if err != nil {
+			results = append(results, BackupImport{Name: hdr.Name, Status: "rejected", Error: err.Error()})
+			continue
+		}
+
+		if !isBackup {
+			if _, err := os.Stat(dest); err == nil {
+				continue
+			}
+			if err := importObject(dest, tr, key); err != nil {
+				results = append(results, BackupImport{Name: hdr.Name, Status: "rejected", Error: err.Error()})
+			}
+			continue
+		}
+
+		if hdr.Size > maxArchiveMetadataBytes {
+			results = append(results, BackupImport{Name: hdr.Name, Status: "rejected", Error: "backup entry is too large"})
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s from archive: %w", hdr.Name, err)
+		}
+
+		result := BackupImport{Name: filepath.Base(dest)}
+		existing, err := os.ReadFile(dest)
+		switch {
+		case err == nil && bytes.Equal(existing, data):
+			result.Status = "exists"
+		case err == nil:
+			result.Status = "conflict"
+			result.Error = "a different backup with the same name already exists"
+		default:
+			if err := writeFileAtomic(dest, data, 0600); err != nil {
+				return nil, err
+			}
+			result.Status = "imported"
+		}
+		results = append(results, result)
+	}
+
+	for i, res := range results {
+		if res.Status != "imported" {
+			continue
+		}
+		meta, err := readBackupMetadata(filepath.Join(dir, res.Name))
+		if err != nil {
+			results[i].Status = "corrupt"
+			results[i].Error = err.Error()
+			continue
+		}
+		p, err := objectPath(dir, meta.Object, meta.Compression, meta.Encrypted)
+		if err != nil {
+			results[i].Status = "corrupt"
+			results[i].Error = err.Error()
+			continue
+		}
+		if _, err := os.Stat(p); err != nil {
+			results[i].Status = "missing"
+			results[i].Error = fmt.Sprintf("object %s is not in the archive or the local store", meta.Object)
+		}
+	}
+
+	return results, nil
+}
+
+func archiveDestination(dir, name string) (string, bool, error) {
+	clean := path.Clean(name)
+	parts := strings.Split(clean, "/")
+	for _, elem := range parts {
+		// A backslash is a separator on Windows, so an element like ..\x
+		// would escape dir once it goes through filepath.Join.
+		if strings.Contains(elem, `\`) || filepath.Base(elem) != elem || !filepath.IsLocal(elem) {
+			return "", false, fmt.Errorf("unexpected archive entry %q", name)
+		}
+	}
+
+	switch {
+	case len(parts) == 2 && parts[0] == archiveBackupsDir && isBackupMetadata(parts[1]):
+		return filepath.Join(dir, parts[1]), true, nil
+	case len(parts) == 3 && parts[0] == objectsDirName && len(parts[1]) == 2 && strings.HasPrefix(parts[2], parts[1]):
+		return filepath.Join(dir, objectsDirName, parts[1], parts[2]), false, nil
+	default:
+		return "", false, fmt.Errorf("unexpected archive entry %q", name)
+	}
+}
+
+func importObject(dest string, r io.Reader, key secretKey) error {
+	name := filepath.Base(dest)
+	sum, encrypted := strings.CutSuffix(name, ".enc")
+	compression := "none"
+	if s, ok := strings.CutSuffix(sum, ".gz"); ok {
+		sum, compression = s, "gzip"
+	} else if s, ok := strings.CutSuffix(sum, ".zst"); ok {
+		sum, compression = s, "zstd"
+	}
+	if encrypted && key.empty() {
+		return fmt.Errorf("cannot verify encrypted object %s: %w", name, ErrBackupKeyMissing)
+	}
+
+	dir := filepath.Dir(dest)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("creating directory %s: %w", dir, err)
+	}
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("creating temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	tee := io.TeeReader(r, tmp)
+	var content io.Reader = tee
+	if encrypted {
+		if content, err = newDecryptReader(tee, key); err != nil {
+			return fmt.Errorf("object %s is corrupt: %w", name, err)
+		}
+	}
+	dr, err := decompressReader(content, compression)
+	if err != nil {
+		return fmt.Errorf("object %s is corrupt: %w", name, err)
+	}
+	defer dr.Close()
+
+	plain := sha256.New()
+	hashes := []hash.Hash{plain}
+	if encrypted {
+		keyed, _, err := newObjectHash(key)
+		if err != nil {
+			return err
+		}
+		hashes = append(hashes, keyed)
+	}
+	writers := make([]io.Writer, len(hashes))
+	for i, h := range hashes {
+		writers[i] = h
+	}
+	if _, err := io.Copy(io.MultiWriter(writers...), dr); err != nil {
+		return fmt.Errorf("object %s is corrupt: %w", name, err)
+	}
+	if _, err := io.Copy(io.Discard, tee); err != nil {
+		return fmt.Errorf("reading object %s: %w", name, err)
+	}
+
+	matched := false
+	for _, h := range hashes {
+		if hex.EncodeToString(h.Sum(nil)) == sum {
+			matched = true
+		}
+	}
+	if !matched {
+		return fmt.Errorf("object %s does not match its content hash", name)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing %s: %w", dest, err)
+	}
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		return fmt.Errorf("writing %s: %w", dest, err)
+	}
+	return nil
+}
+
+func writeFileAtomic(dest string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(dest)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("creating directory %s: %w", dir, err)
+	}
+
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("creating temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing %s: %w", dest, err)
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing %s: %w", dest, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing %s: %w", dest, err)
+	}
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		return fmt.Errorf("writing %s: %w", dest, err)
+	}
+	return nil
+}
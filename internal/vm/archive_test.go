@@ -0,0 +1,201 @@
+package vm
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackupFilter_Match(t *testing.T) {
+	ts := time.Date(2026, 2, 5, 15, 30, 0, 0, time.UTC)
+	b := BackupInfo{Host: "10.0.0.1", Remote: "/etc/nginx/nginx.conf", Run: "run-1", Timestamp: ts}
+
+	tests := []struct {
+		name   string
+		filter BackupFilter
+		want   bool
+	}{
+		{name: "empty filter", filter: BackupFilter{}, want: true},
+		{name: "host match", filter: BackupFilter{Host: "10.0.0.1"}, want: true},
+		{name: "host mismatch", filter: BackupFilter{Host: "10.0.0.2"}, want: false},
+		{name: "path glob", filter: BackupFilter{Path: "/etc/nginx/*"}, want: true},
+		{name: "path mismatch", filter: BackupFilter{Path: "/etc/app/*"}, want: false},
+		{name: "run match", filter: BackupFilter{Run: "run-1"}, want: true},
+		{name: "run mismatch", filter: BackupFilter{Run: "run-2"}, want: false},
+		{name: "in range", filter: BackupFilter{Since: ts.Add(-time.Hour), Until: ts.Add(time.Hour)}, want: true},
+		{name: "before range", filter: BackupFilter{Since: ts.Add(time.Hour)}, want: false},
+		{name: "after range", filter: BackupFilter{Until: ts.Add(-time.Hour)}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Match(b); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExportImportBackups(t *testing.T) {
+	src := t.TempDir()
+	writeTestBackup(t, src, "a.conf", "alpha")
+	writeTestBackup(t, src, "b.conf", "bravo")
+
+	var archive bytes.Buffer
+	exported, err := ExportBackups(BackupConfig{Dir: src}, BackupFilter{Path: "/etc/a.conf"}, &archive)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(exported) != 1 {
+		t.Fatalf("got %d exported backups, want 1", len(exported))
+	}
+
+	dst := filepath.Join(t.TempDir(), "backups")
+	cfg := BackupConfig{Dir: dst}
+
+	t.Run("import into empty store", func(t *testing.T) {
+		results, err := ImportBackups(cfg, bytes.NewReader(archive.Bytes()))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(results) != 1 || results[0].Status != "imported" {
+			t.Fatalf("got %+v, want one imported backup", results)
+		}
+		if info, _ := os.Stat(dst); info.Mode().Perm() != 0700 {
+			t.Errorf("backup directory mode = %v, want 0700", info.Mode().Perm())
+		}
+
+		path, err := FindLatestBackup(cfg, "10.0.0.1", "/etc/a.conf")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		r, err := OpenBackup(cfg, path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer r.Close()
+		got, _ := io.ReadAll(r)
+		if string(got) != "alpha" {
+			t.Errorf("got %q, want %q", got, "alpha")
+		}
+	})
+
+	t.Run("reimport is a no-op", func(t *testing.T) {
+		results, err := ImportBackups(cfg, bytes.NewReader(archive.Bytes()))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(results) != 1 || results[0].Status != "exists" {
+			t.Fatalf("got %+v, want one existing backup", results)
+		}
+	})
+
+	t.Run("conflicting backup", func(t *testing.T) {
+		name := singleBackupName(t, dst)
+		os.WriteFile(filepath.Join(dst, name), []byte(`{"object":"sha256:`+strings.Repeat("1", 64)+`"}`), 0600)
+
+		results, err := ImportBackups(cfg, bytes.NewReader(archive.Bytes()))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(results) != 1 || results[0].Status != "conflict" {
+			t.Fatalf("got %+v, want one conflict", results)
+		}
+	})
+}
+
+func TestImportBackups_RejectsTamperedObject(t *testing.T) {
+	src := t.TempDir()
+	_, meta := writeTestBackup(t, src, "a.conf", "alpha")
+	_, other := writeTestBackup(t, src, "b.conf", "bravo")
+
+	objPath, _ := objectPath(src, meta.Object, meta.Compression, false)
+	otherPath, _ := objectPath(src, other.Object, other.Compression, false)
+	forged, err := os.ReadFile(otherPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metaPath := filepath.Join(src, "10.0.0.1_etc_a.conf_20260205-153000.json")
+	metaData, err := os.ReadFile(metaPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var archive bytes.Buffer
+	gw := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gw)
+	rel, _ := filepath.Rel(src, objPath)
+	for _, entry := range []struct {
+		name string
+		data []byte
+	}{
+		{filepath.ToSlash(rel), forged},
+		{"backups/" + filepath.Base(metaPath), metaData},
+	} {
+		tw.WriteHeader(&tar.Header{Name: entry.name, Mode: 0600, Size: int64(len(entry.data))})
+		tw.Write(entry.data)
+	}
+	tw.Close()
+	gw.Close()
+
+	dst := t.TempDir()
+	results, err := ImportBackups(BackupConfig{Dir: dst}, &archive)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(results) != 2 || results[0].Status != "rejected" || !strings.Contains(results[0].Error, "does not match its content hash") {
+		t.Fatalf("got %+v, want rejected object", results)
+	}
+	if results[1].Status != "missing" {
+		t.Errorf("got %+v, want backup with missing object", results[1])
+	}
+	if _, err := os.Stat(filepath.Join(dst, rel)); err == nil {
+		t.Error("tampered object was written to the store")
+	}
+}
+
+func singleBackupName(t *testing.T, dir string) string {
+	t.Helper()
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(matches) != 1 {
+		t.Fatalf("got %d backups, want 1", len(matches))
+	}
+	return filepath.Base(matches[0])
+}
+
+func TestArchiveDestination(t *testing.T) {
+	dir := "/store"
+
+	tests := []struct {
+		name    string
+		entry   string
+		backup  bool
+		wantErr bool
+	}{
+		{name: "backup entry", entry: "backups/10.0.0.1_etc_a.conf_20260205-153000.json", backup: true},
+		{name: "object entry", entry: "objects/ab/ab" + strings.Repeat("0", 62) + ".gz"},
+		{name: "path traversal", entry: "backups/../../etc/passwd", wantErr: true},
+		{name: "nested backup", entry: "backups/x/y.json", wantErr: true},
+		{name: "backslash backup", entry: `backups/..\..\clients\acme.json`, wantErr: true},
+		{name: "backslash object", entry: `objects/ab/ab\..\..\x`, wantErr: true},
+		{name: "unknown entry", entry: "README", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, backup, err := archiveDestination(dir, tt.entry)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("archiveDestination() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if backup != tt.backup {
+				t.Errorf("got backup %v, want %v", backup, tt.backup)
+			}
+		})
+	}
+}
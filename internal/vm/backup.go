@@ -1,44 +1,296 @@
 package vm
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strings"
 	"time"
 )
 
-const BackupDir = "./backups"
+const DefaultRemoteBackupDir = "/var/backups/onevm"
+
+const DefaultBackupCompression = "gzip"
+
+var ErrBackupKeyMissing = errors.New("backup is encrypted but no key is configured (set ONEVM_BACKUP_KEY, ONEVM_BACKUP_PASSPHRASE or backup.key_file)")
+
+type BackupConfig struct {
+	Dir         string `json:"dir,omitempty"`
+	Mode        string `json:"mode,omitempty"`
+	RemoteDir   string `json:"remote_dir,omitempty"`
+	Compression string `json:"compression,omitempty"`
+	Encrypt     bool   `json:"encrypt,omitempty"`
+	KeyFile     string `json:"key_file,omitempty"`
+	RunID       string `json:"-"`
+}
 
 type BackupInfo struct {
-	Path      string
-	Timestamp time.Time
+	Path       string
+	Timestamp  time.Time
+	Host       string
+	Remote     string
+	RemoteCopy string
+	Run        string
+	Size       int64
+}
+
+// BackupMetadata describes one backup. Object is set when the content is in
+// the local store, RemoteCopy when a copy was kept on the host itself; with
+// mode remote only RemoteCopy is set.
+type BackupMetadata struct {
+	Host        string    `json:"host"`
+	Remote      string    `json:"remote"`
+	Created     time.Time `json:"created"`
+	Object      string    `json:"object,omitempty"`
+	RemoteCopy  string    `json:"remote_copy,omitempty"`
+	Size        int64     `json:"size"`
+	Compression string    `json:"compression,omitempty"`
+	Encrypted   bool      `json:"encrypted,omitempty"`
+	Run         string    `json:"run,omitempty"`
+}
+
+type BackupRecord struct {
+	Local  string
+	Remote string
+}
+
+func DefaultBackupDir() string {
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return filepath.Join(dir, "onevm", "backups")
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".", "backups")
+	}
+
+	switch runtime.GOOS {
+	case "windows":
+		if dir := os.Getenv("LocalAppData"); dir != "" {
+			return filepath.Join(dir, "onevm", "backups")
+		}
+		return filepath.Join(home, "AppData", "Local", "onevm", "backups")
+	case "darwin":
+		return filepath.Join(home, "Library", "Application Support", "onevm", "backups")
+	default:
+		return filepath.Join(home, ".local", "share", "onevm", "backups")
+	}
+}
+
+func (b BackupConfig) LocalDir() string {
+	if b.Dir == "" {
+		return DefaultBackupDir()
+	}
+	return b.Dir
+}
+
+// legacyBackupDir is where backups went before the per-user default. Without
+// backup.dir it is still searched, so older backups can be rolled back.
+const legacyBackupDir = "backups"
+
+func (b BackupConfig) searchDirs() []string {
+	dir := b.LocalDir()
+	if b.Dir != "" {
+		return []string{dir}
+	}
+	abs, _ := filepath.Abs(dir)
+	legacy, _ := filepath.Abs(legacyBackupDir)
+	if abs == legacy {
+		return []string{dir}
+	}
+	return []string{dir, legacyBackupDir}
+}
+
+func (b BackupConfig) RemoteBackupDir() string {
+	if b.RemoteDir == "" {
+		return DefaultRemoteBackupDir
+	}
+	return b.RemoteDir
 }
 
-func CreateBackup(transfer *SFTPTransfer, remotePath, host string) (string, error) {
+func (b BackupConfig) compression() string {
+	if b.Compression == "" {
+		return DefaultBackupCompression
+	}
+	return b.Compression
+}
+
+func (b BackupConfig) backupKey() (secretKey, error) {
+	if v := os.Getenv("ONEVM_BACKUP_KEY"); v != "" {
+		key, err := parseSecretKey(v)
+		if err != nil {
+			return secretKey{}, fmt.Errorf("ONEVM_BACKUP_KEY: %w", err)
+		}
+		return secretKey{key: key}, nil
+	}
+	if b.KeyFile != "" {
+		key, err := readSecretKeyFile(b.KeyFile)
+		if err != nil {
+			return secretKey{}, err
+		}
+		return secretKey{key: key}, nil
+	}
+	if v := os.Getenv("ONEVM_BACKUP_PASSPHRASE"); v != "" {
+		return secretKey{passphrase: []byte(v)}, nil
+	}
+	return secretKey{}, nil
+}
+
+func (b BackupConfig) encryptionKey() (secretKey, error) {
+	if !b.Encrypt {
+		return secretKey{}, nil
+	}
+	key, err := b.backupKey()
+	if err != nil {
+		return secretKey{}, err
+	}
+	if key.empty() {
+		return secretKey{}, errors.New("backup encryption is enabled but no key is configured (set ONEVM_BACKUP_KEY, ONEVM_BACKUP_PASSPHRASE or backup.key_file)")
+	}
+	return key, nil
+}
+
+func (b BackupConfig) storesLocal() bool {
+	return b.Mode == "" || b.Mode == "local" || b.Mode == "both"
+}
+
+func (b BackupConfig) storesRemote() bool {
+	return b.Mode == "remote" || b.Mode == "both"
+}
+
+func (b BackupConfig) Validate() error {
+	if errs := b.validationErrors(); len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (b BackupConfig) validationErrors() ValidationErrors {
+	var errs ValidationErrors
+	switch b.Mode {
+	case "", "local", "remote", "both":
+	default:
+		errs = append(errs, ValidationError{Path: "backup.mode", Message: fmt.Sprintf("mode %q must be local, remote or both", b.Mode)})
+	}
+	if b.RemoteDir != "" && !path.IsAbs(b.RemoteDir) {
+		errs = append(errs, ValidationError{Path: "backup.remote_dir", Message: fmt.Sprintf("remote_dir %q must be an absolute path", b.RemoteDir)})
+	}
+	switch b.Compression {
+	case "", "gzip", "zstd", "none":
+	default:
+		errs = append(errs, ValidationError{Path: "backup.compression", Message: fmt.Sprintf("compression %q must be gzip, zstd or none", b.Compression)})
+	}
+	return errs
+}
+
+func CreateBackup(transfer *SFTPTransfer, remotePath, host string, cfg BackupConfig) (BackupRecord, error) {
+	var record BackupRecord
+
 	if !transfer.FileExists(remotePath) {
-		return "", nil
+		return record, nil
 	}
 
-	timestamp := time.Now().Format("20060102-150405")
+	now := time.Now()
+	timestamp := now.Format("20060102-150405")
 	safeName := strings.ReplaceAll(remotePath, "/", "_")
-	backupName := fmt.Sprintf("%s%s_%s", host, safeName, timestamp)
-	backupPath := filepath.Join(BackupDir, backupName)
 
-	if err := os.MkdirAll(BackupDir, 0755); err != nil {
-		return "", fmt.Errorf("creating backup directory: %w", err)
+	dir := cfg.LocalDir()
+	backupPath := filepath.Join(dir, fmt.Sprintf("%s%s_%s.json", host, safeName, timestamp))
+	meta := BackupMetadata{
+		Host:    host,
+		Remote:  remotePath,
+		Created: now,
+		Run:     cfg.RunID,
+	}
+
+	if cfg.storesLocal() {
+		key, err := cfg.encryptionKey()
+		if err != nil {
+			return record, err
+		}
+
+		expected, err := transfer.Size(remotePath)
+		if err != nil {
+			return record, fmt.Errorf("downloading backup of %s: %w", remotePath, err)
+		}
+
+		remote, err := transfer.Open(remotePath)
+		if err != nil {
+			return record, fmt.Errorf("downloading backup of %s: %w", remotePath, err)
+		}
+		defer remote.Close()
+
+		object, size, err := storeObject(dir, cfg.compression(), key, remote)
+		if err != nil {
+			return record, fmt.Errorf("downloading backup of %s: %w", remotePath, err)
+		}
+		if size != expected {
+			return record, fmt.Errorf("backup of %s is incomplete: downloaded %d bytes, remote reports %d", remotePath, size, expected)
+		}
+
+		meta.Object = object
+		meta.Size = size
+		meta.Compression = cfg.compression()
+		meta.Encrypted = !key.empty()
+		if err := writeBackupMetadata(backupPath, meta); err != nil {
+			return record, err
+		}
+		record.Local = backupPath
+	}
+
+	if cfg.storesRemote() {
+		copyPath := path.Join(cfg.RemoteBackupDir(), fmt.Sprintf("%s_%s", strings.TrimPrefix(safeName, "_"), timestamp))
+
+		if err := transfer.Copy(remotePath, copyPath); err != nil {
+			return record, fmt.Errorf("copying remote backup of %s: %w", remotePath, err)
+		}
+		record.Remote = copyPath
+
+		// Record the copy locally too, so rollback can find it.
+		meta.RemoteCopy = copyPath
+		if meta.Object == "" {
+			size, err := transfer.Size(copyPath)
+			if err != nil {
+				return record, fmt.Errorf("copying remote backup of %s: %w", remotePath, err)
+			}
+			meta.Size = size
+			if err := os.MkdirAll(dir, 0700); err != nil {
+				return record, fmt.Errorf("creating backup directory: %w", err)
+			}
+		}
+		if err := writeBackupMetadata(backupPath, meta); err != nil {
+			return record, err
+		}
 	}
 
-	if err := transfer.Download(remotePath, backupPath); err != nil {
-		return "", fmt.Errorf("downloading backup of %s: %w", remotePath, err)
+	return record, nil
+}
+
+func ListBackups(cfg BackupConfig) ([]BackupInfo, error) {
+	var backups []BackupInfo
+	for _, dir := range cfg.searchDirs() {
+		found, err := listBackupDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, found...)
 	}
 
-	return backupPath, nil
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Timestamp.After(backups[j].Timestamp)
+	})
+
+	return backups, nil
 }
 
-func ListBackups() ([]BackupInfo, error) {
-	entries, err := os.ReadDir(BackupDir)
+func listBackupDir(dir string) ([]BackupInfo, error) {
+	entries, err := os.ReadDir(dir)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, nil
@@ -51,25 +303,40 @@ func ListBackups() ([]BackupInfo, error) {
 		if entry.IsDir() {
 			continue
 		}
+		backupPath := filepath.Join(dir, entry.Name())
+
+		if isBackupMetadata(backupPath) {
+			meta, err := readBackupMetadata(backupPath)
+			if err != nil {
+				continue
+			}
+			backups = append(backups, BackupInfo{
+				Path:       backupPath,
+				Timestamp:  meta.Created,
+				Host:       meta.Host,
+				Remote:     meta.Remote,
+				RemoteCopy: meta.RemoteCopy,
+				Run:        meta.Run,
+				Size:       meta.Size,
+			})
+			continue
+		}
+
 		info, err := entry.Info()
 		if err != nil {
 			continue
 		}
 		backups = append(backups, BackupInfo{
-			Path:      filepath.Join(BackupDir, entry.Name()),
+			Path:      backupPath,
 			Timestamp: info.ModTime(),
+			Size:      info.Size(),
 		})
 	}
-
-	sort.Slice(backups, func(i, j int) bool {
-		return backups[i].Timestamp.After(backups[j].Timestamp)
-	})
-
 	return backups, nil
 }
 
-func FindLatestBackup(host, remotePath string) (string, error) {
-	backups, err := ListBackups()
+func FindLatestBackup(cfg BackupConfig, host, remotePath string) (string, error) {
+	backups, err := ListBackups(cfg)
 	if err != nil {
 		return "", err
 	}
@@ -78,6 +345,12 @@ func FindLatestBackup(host, remotePath string) (string, error) {
 	prefix := host + safeName
 
 	for _, b := range backups {
+		if b.Remote != "" {
+			if b.Host == host && b.Remote == remotePath {
+				return b.Path, nil
+			}
+			continue
+		}
 		if strings.HasPrefix(filepath.Base(b.Path), prefix) {
 			return b.Path, nil
 		}
@@ -85,3 +358,83 @@ func FindLatestBackup(host, remotePath string) (string, error) {
 
 	return "", fmt.Errorf("no backup found for %s on %s", remotePath, host)
 }
+
+func OpenBackup(cfg BackupConfig, backupPath string) (io.ReadCloser, error) {
+	if !isBackupMetadata(backupPath) {
+		f, err := os.Open(backupPath)
+		if err != nil {
+			return nil, fmt.Errorf("opening backup %s: %w", backupPath, err)
+		}
+		return f, nil
+	}
+
+	meta, err := readBackupMetadata(backupPath)
+	if err != nil {
+		return nil, err
+	}
+	if meta.Object == "" {
+		return nil, fmt.Errorf("backup %s is only kept on %s at %s", backupPath, meta.Host, meta.RemoteCopy)
+	}
+
+	var key secretKey
+	if meta.Encrypted {
+		key, err = cfg.backupKey()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return openObject(filepath.Dir(backupPath), meta.Object, meta.Compression, meta.Encrypted, key)
+}
+
+func RestoreBackup(transfer *SFTPTransfer, cfg BackupConfig, backupPath, remotePath string) error {
+	if isBackupMetadata(backupPath) {
+		meta, err := readBackupMetadata(backupPath)
+		if err != nil {
+			return err
+		}
+		if meta.Object == "" {
+			return transfer.Copy(meta.RemoteCopy, remotePath)
+		}
+	}
+
+	r, err := OpenBackup(cfg, backupPath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	return transfer.UploadFrom(r, remotePath)
+}
+
+func isBackupMetadata(backupPath string) bool {
+	return strings.HasSuffix(backupPath, ".json")
+}
+
+func readBackupMetadata(backupPath string) (BackupMetadata, error) {
+	var meta BackupMetadata
+
+	data, err := os.ReadFile(backupPath)
+	if err != nil {
+		return meta, fmt.Errorf("reading backup %s: %w", backupPath, err)
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return meta, fmt.Errorf("parsing backup %s: %w", backupPath, err)
+	}
+	if meta.Object == "" && meta.RemoteCopy == "" {
+		return meta, fmt.Errorf("backup %s has no object reference", backupPath)
+	}
+
+	return meta, nil
+}
+
+func writeBackupMetadata(backupPath string, meta BackupMetadata) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding backup metadata: %w", err)
+	}
+	if err := os.WriteFile(backupPath, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("writing backup %s: %w", backupPath, err)
+	}
+	return nil
+}
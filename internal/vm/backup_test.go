@@ -2,11 +2,16 @@ package vm
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestListBackups_EmptyDir(t *testing.T) {
-	backups, err := ListBackups()
+	cfg := BackupConfig{Dir: filepath.Join(t.TempDir(), "missing")}
+
+	backups, err := ListBackups(cfg)
 	if err != nil && !os.IsNotExist(err) {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -14,8 +19,131 @@ func TestListBackups_EmptyDir(t *testing.T) {
 }
 
 func TestFindLatestBackup_NotFound(t *testing.T) {
-	_, err := FindLatestBackup("nonexistent-host", "/etc/nonexistent.conf")
+	cfg := BackupConfig{Dir: t.TempDir()}
+
+	_, err := FindLatestBackup(cfg, "nonexistent-host", "/etc/nonexistent.conf")
 	if err == nil {
 		t.Fatal("expected error when no backup exists")
 	}
 }
+
+func TestFindLatestBackup_CustomDir(t *testing.T) {
+	dir := t.TempDir()
+	name := "10.0.0.1_etc_app.conf_20260205-153000"
+	os.WriteFile(filepath.Join(dir, name), []byte("old"), 0644)
+
+	path, err := FindLatestBackup(BackupConfig{Dir: dir}, "10.0.0.1", "/etc/app.conf")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path != filepath.Join(dir, name) {
+		t.Errorf("got %q, want %q", path, filepath.Join(dir, name))
+	}
+}
+
+func TestFindLatestBackup_RemoteCopy(t *testing.T) {
+	dir := t.TempDir()
+	backupPath := filepath.Join(dir, "10.0.0.1_etc_app.conf_20260205-153000.json")
+	meta := BackupMetadata{
+		Host:       "10.0.0.1",
+		Remote:     "/etc/app.conf",
+		Created:    time.Date(2026, 2, 5, 15, 30, 0, 0, time.UTC),
+		RemoteCopy: "/var/backups/onevm/etc_app.conf_20260205-153000",
+		Size:       3,
+	}
+	if err := writeBackupMetadata(backupPath, meta); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := BackupConfig{Dir: dir, Mode: "remote"}
+	path, err := FindLatestBackup(cfg, "10.0.0.1", "/etc/app.conf")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path != backupPath {
+		t.Errorf("got %q, want %q", path, backupPath)
+	}
+
+	backups, err := ListBackups(cfg)
+	if err != nil || len(backups) != 1 || backups[0].RemoteCopy != meta.RemoteCopy {
+		t.Errorf("ListBackups() = %+v, %v", backups, err)
+	}
+	if _, err := OpenBackup(cfg, backupPath); err == nil || !strings.Contains(err.Error(), "only kept on 10.0.0.1") {
+		t.Errorf("OpenBackup() error = %v", err)
+	}
+
+	checks, err := VerifyBackups(cfg)
+	if err != nil || len(checks) != 1 || checks[0].Status != "remote" {
+		t.Errorf("VerifyBackups() = %+v, %v", checks, err)
+	}
+}
+
+func TestBackupConfig(t *testing.T) {
+	t.Run("default dir is absolute", func(t *testing.T) {
+		dir := BackupConfig{}.LocalDir()
+		if !filepath.IsAbs(dir) {
+			t.Errorf("got %q, want absolute path", dir)
+		}
+	})
+
+	t.Run("default remote dir", func(t *testing.T) {
+		if got := (BackupConfig{}).RemoteBackupDir(); got != DefaultRemoteBackupDir {
+			t.Errorf("got %q, want %q", got, DefaultRemoteBackupDir)
+		}
+	})
+
+	tests := []struct {
+		name    string
+		cfg     BackupConfig
+		local   bool
+		remote  bool
+		wantErr bool
+	}{
+		{name: "default", cfg: BackupConfig{}, local: true},
+		{name: "local", cfg: BackupConfig{Mode: "local"}, local: true},
+		{name: "remote", cfg: BackupConfig{Mode: "remote"}, remote: true},
+		{name: "both", cfg: BackupConfig{Mode: "both", RemoteDir: "/srv/backups"}, local: true, remote: true},
+		{name: "unknown mode", cfg: BackupConfig{Mode: "cloud"}, wantErr: true},
+		{name: "relative remote dir", cfg: BackupConfig{Mode: "remote", RemoteDir: "backups"}, remote: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.cfg.storesLocal() != tt.local {
+				t.Errorf("storesLocal() = %v, want %v", tt.cfg.storesLocal(), tt.local)
+			}
+			if tt.cfg.storesRemote() != tt.remote {
+				t.Errorf("storesRemote() = %v, want %v", tt.cfg.storesRemote(), tt.remote)
+			}
+		})
+	}
+}
+
+func TestFindLatestBackup_LegacyDir(t *testing.T) {
+	wd, _ := os.Getwd()
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	name := "10.0.0.1_etc_app.conf_20260205-153000"
+	os.Mkdir(legacyBackupDir, 0700)
+	os.WriteFile(filepath.Join(legacyBackupDir, name), []byte("old"), 0644)
+
+	path, err := FindLatestBackup(BackupConfig{}, "10.0.0.1", "/etc/app.conf")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path != filepath.Join(legacyBackupDir, name) {
+		t.Errorf("got %q, want %q", path, filepath.Join(legacyBackupDir, name))
+	}
+
+	if _, err := FindLatestBackup(BackupConfig{Dir: t.TempDir()}, "10.0.0.1", "/etc/app.conf"); err == nil {
+		t.Error("expected ./backups to be ignored when backup.dir is set")
+	}
+}
@@ -0,0 +1,115 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+type Condition struct {
+	Match string `json:"match,omitempty"`
+	Probe string `json:"probe,omitempty"`
+}
+
+func (c *Condition) validate() (string, error) {
+	if c.Match == "" && c.Probe == "" {
+		return "", errors.New("condition needs match or probe")
+	}
+	if c.Match != "" {
+		if _, err := parseSelector(c.Match); err != nil {
+			return "match", err
+		}
+	}
+	return "", nil
+}
+
+func hostVars(alias string, server ServerConfig) map[string]string {
+	vars := make(map[string]string, len(server.Labels)+3)
+	for k, v := range server.Labels {
+		vars[k] = v
+	}
+	vars["alias"] = alias
+	vars["host"] = server.Host
+	vars["user"] = server.User
+	return vars
+}
+
+func matchCondition(cond *Condition, vars map[string]string) bool {
+	if cond.Match == "" {
+		return true
+	}
+	sel, err := parseSelector(cond.Match)
+	if err != nil {
+		return false
+	}
+	return sel.matches(vars)
+}
+
+func (s *runSession) probe(cmd string) (bool, error) {
+	_, err := s.client.Execute(cmd)
+	if err == nil {
+		return true, nil
+	}
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return false, nil
+	}
+	return false, err
+}
+
+func (s *runSession) evalCondition(cond *Condition) (bool, error) {
+	if !matchCondition(cond, hostVars(s.alias, s.server)) {
+		return false, nil
+	}
+	if cond.Probe == "" {
+		return true, nil
+	}
+	return s.probe(cond.Probe)
+}
+
+func (s *runSession) skipReason(step TaskStep) (string, error) {
+	if step.When != nil {
+		ok, err := s.evalCondition(step.When)
+		if err != nil {
+			return "", fmt.Errorf("evaluating when: %w", err)
+		}
+		if !ok {
+			return "when " + describeCondition(step.When) + " is false", nil
+		}
+	}
+	if step.Unless != nil {
+		ok, err := s.evalCondition(step.Unless)
+		if err != nil {
+			return "", fmt.Errorf("evaluating unless: %w", err)
+		}
+		if ok {
+			return "unless " + describeCondition(step.Unless) + " is true", nil
+		}
+	}
+	return "", nil
+}
+
+func dryRunSkipReason(step TaskStep, vars map[string]string) string {
+	if vars == nil {
+		return ""
+	}
+	if step.When != nil && !matchCondition(step.When, vars) {
+		return "when " + describeCondition(step.When) + " is false"
+	}
+	if step.Unless != nil && step.Unless.Probe == "" && matchCondition(step.Unless, vars) {
+		return "unless " + describeCondition(step.Unless) + " is true"
+	}
+	return ""
+}
+
+func describeCondition(cond *Condition) string {
+	switch {
+	case cond.Match != "" && cond.Probe != "":
+		return fmt.Sprintf("match %q and probe %q", cond.Match, cond.Probe)
+	case cond.Match != "":
+		return fmt.Sprintf("match %q", cond.Match)
+	default:
+		return fmt.Sprintf("probe %q", cond.Probe)
+	}
+}
@@ -0,0 +1,83 @@
+package vm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMatchCondition(t *testing.T) {
+	vars := hostVars("web1", ServerConfig{Host: "10.0.0.1", User: "deploy", Labels: map[string]string{"env": "prod", "role": "web"}})
+
+	tests := []struct {
+		match string
+		want  bool
+	}{
+		{"", true},
+		{"env=prod", true},
+		{"env=prod,role!=db", true},
+		{"alias=web1", true},
+		{"user=root", false},
+		{"host=10.0.0.1,env=dev", false},
+	}
+
+	for _, tt := range tests {
+		if got := matchCondition(&Condition{Match: tt.match}, vars); got != tt.want {
+			t.Errorf("matchCondition(%q) = %v, want %v", tt.match, got, tt.want)
+		}
+	}
+}
+
+func TestConditionalStepsDryRun(t *testing.T) {
+	cfg := &ClientConfig{
+		Hosts: map[string]ServerConfig{
+			"prod": {Host: "h", User: "u", Key: "k", Labels: map[string]string{"env": "prod"}},
+			"dev":  {Host: "h2", User: "u", Key: "k", Labels: map[string]string{"env": "dev"}},
+		},
+		Tasks: map[string][]TaskStep{"deploy": {
+			{Type: "exec", Run: "git pull"},
+			{Type: "exec", Run: "warm-cache", When: &Condition{Match: "env=prod"}},
+			{Type: "exec", Run: "seed-db", Unless: &Condition{Match: "env=prod"}},
+			{Type: "exec", Run: "migrate", Unless: &Condition{Probe: "test -f /var/app/.migrated"}},
+		}},
+	}
+
+	results := ExecuteRun(cfg, "deploy", []string{"prod", "dev"}, true, Confirmation{})
+
+	summary := func(r RunResult) string {
+		var out []string
+		for _, s := range r.Steps {
+			out = append(out, s.Status)
+		}
+		return strings.Join(out, " ")
+	}
+
+	if got := summary(results[0]); got != "dry-run dry-run skipped dry-run" {
+		t.Errorf("prod steps = %s", got)
+	}
+	if got := summary(results[1]); got != "dry-run skipped dry-run dry-run" {
+		t.Errorf("dev steps = %s", got)
+	}
+	if reason := results[1].Steps[1].Reason; reason != `when match "env=prod" is false` {
+		t.Errorf("reason = %q", reason)
+	}
+}
+
+func TestValidateConditions(t *testing.T) {
+	cfg := &ClientConfig{
+		Hosts: map[string]ServerConfig{"prod": {Host: "h", User: "u", Key: "k"}},
+		Tasks: map[string][]TaskStep{"t": {
+			{Type: "exec", Run: "a", When: &Condition{}},
+			{Type: "exec", Run: "b", Unless: &Condition{Match: "env"}},
+		}},
+	}
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for _, want := range []string{"tasks.t[0].when: condition needs match or probe", "tasks.t[1].unless.match: invalid selector"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+}
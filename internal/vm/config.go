@@ -1,85 +1,396 @@
 package vm
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
 )
 
 type ClientConfig struct {
-	Hosts map[string]ServerConfig `json:"hosts"`
-	Tasks map[string][]TaskStep   `json:"tasks"`
+	Include   []string                `json:"include,omitempty"`
+	Hosts     map[string]ServerConfig `json:"hosts"`
+	Tasks     map[string][]TaskStep   `json:"tasks"`
+	Groups    map[string][]string     `json:"groups,omitempty"`
+	Backup    BackupConfig            `json:"backup,omitempty"`
+	Normalize NormalizeConfig         `json:"normalize,omitempty"`
+
+	// TaskOptions holds the settings of tasks written in the object form;
+	// tasks written as a plain step array have no entry.
+	TaskOptions map[string]TaskOptions `json:"-"`
+
+	source   string
+	origins  map[string]string
+	unknown  []ValidationError
+	shadowed map[string]string
+}
+
+type TaskOptions struct {
+	OnFailure    []TaskStep        `json:"on_failure,omitempty"`
+	Params       map[string]string `json:"params,omitempty"`
+	Hosts        []string          `json:"hosts,omitempty"`
+	AllowedHosts []string          `json:"allowed_hosts,omitempty"`
+	LintIgnore   []string          `json:"lint_ignore,omitempty"`
+}
+
+// Task is the object form of a task: its steps plus options.
+type Task struct {
+	Steps []TaskStep `json:"steps"`
+	TaskOptions
+
+	// object records that the task was written in the object form, so
+	// paths and re-encoding follow the source even without options.
+	object bool
+}
+
+func (t *Task) UnmarshalJSON(data []byte) error {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		*t = Task{}
+		return json.Unmarshal(trimmed, &t.Steps)
+	}
+	type plain Task
+	if err := json.Unmarshal(data, (*plain)(t)); err != nil {
+		return err
+	}
+	t.object = true
+	return nil
+}
+
+func (c *ClientConfig) UnmarshalJSON(data []byte) error {
+	type plain ClientConfig
+	raw := struct {
+		*plain
+		Tasks map[string]json.RawMessage `json:"tasks"`
+	}{plain: (*plain)(c)}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	c.Tasks, c.TaskOptions = nil, nil
+	for _, name := range sortedKeys(raw.Tasks) {
+		var task Task
+		if err := json.Unmarshal(raw.Tasks[name], &task); err != nil {
+			// Errors from a nested Unmarshal are relative to the task; make
+			// them point into the whole document again.
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) {
+				typeErr.Field = joinConfigPath("tasks."+name, typeErr.Field)
+				typeErr.Offset += jsonValueOffset(data, "tasks", name)
+			}
+			return err
+		}
+		c.setTask(name, task)
+	}
+	return nil
+}
+
+func (c ClientConfig) MarshalJSON() ([]byte, error) {
+	type plain ClientConfig
+	tasks := make(map[string]any, len(c.Tasks))
+	for name, steps := range c.Tasks {
+		if opts, ok := c.TaskOptions[name]; ok {
+			tasks[name] = Task{Steps: steps, TaskOptions: opts}
+		} else {
+			tasks[name] = steps
+		}
+	}
+	return json.Marshal(struct {
+		plain
+		Tasks map[string]any `json:"tasks"`
+	}{plain(c), tasks})
+}
+
+func (c *ClientConfig) task(name string) (Task, bool) {
+	steps, ok := c.Tasks[name]
+	opts, object := c.TaskOptions[name]
+	return Task{Steps: steps, TaskOptions: opts, object: object}, ok
+}
+
+// taskStepsPath is the config path of a task's step list: the task itself
+// for the array form, its steps field for the object form.
+func (c *ClientConfig) taskStepsPath(name string) string {
+	if _, object := c.TaskOptions[name]; object {
+		return "tasks." + name + ".steps"
+	}
+	return "tasks." + name
+}
+
+func (c *ClientConfig) setTask(name string, task Task) {
+	if c.Tasks == nil {
+		c.Tasks = map[string][]TaskStep{}
+	}
+	c.Tasks[name] = task.Steps
+	if !task.object && reflect.ValueOf(task.TaskOptions).IsZero() {
+		delete(c.TaskOptions, name)
+		return
+	}
+	if c.TaskOptions == nil {
+		c.TaskOptions = map[string]TaskOptions{}
+	}
+	c.TaskOptions[name] = task.TaskOptions
 }
 
 type TaskStep struct {
-	Type   string `json:"type"`
-	Local  string `json:"local,omitempty"`
-	Remote string `json:"remote,omitempty"`
-	Run    string `json:"run,omitempty"`
+	Type        string            `json:"type"`
+	Local       string            `json:"local,omitempty"`
+	Remote      string            `json:"remote,omitempty"`
+	Run         string            `json:"run,omitempty"`
+	Sudo        bool              `json:"sudo,omitempty"`
+	Args        []string          `json:"args,omitempty"`
+	Interpreter string            `json:"interpreter,omitempty"`
+	Recursive   bool              `json:"recursive,omitempty"`
+	Port        int               `json:"port,omitempty"`
+	URL         string            `json:"url,omitempty"`
+	From        string            `json:"from,omitempty"`
+	Interval    string            `json:"interval,omitempty"`
+	Timeout     string            `json:"timeout,omitempty"`
+	CheckBootID bool              `json:"check_boot_id,omitempty"`
+	Task        string            `json:"task,omitempty"`
+	Params      map[string]string `json:"params,omitempty"`
+	Normalize   string            `json:"normalize,omitempty"`
+	Fixes       []string          `json:"fixes,omitempty"`
+	Validate    string            `json:"validate,omitempty"`
+	When        *Condition        `json:"when,omitempty"`
+	Unless      *Condition        `json:"unless,omitempty"`
+
+	Retries      int        `json:"retries,omitempty"`
+	RetryDelay   string     `json:"retry_delay,omitempty"`
+	Backoff      float64    `json:"backoff,omitempty"`
+	RetryUntil   *Condition `json:"retry_until,omitempty"`
+	IgnoreErrors bool       `json:"ignore_errors,omitempty"`
 }
 
 func LoadClientConfig(path string) (*ClientConfig, error) {
-	data, err := os.ReadFile(path)
+	cfg, err := loadConfigTree(path, nil)
 	if err != nil {
-		return nil, fmt.Errorf("reading config %s: %w", path, err)
-	}
-
-	var cfg ClientConfig
-	if err := json.Unmarshal(data, &cfg); err != nil {
-		return nil, fmt.Errorf("parsing config: %w", err)
+		return nil, err
 	}
 
 	if err := cfg.Validate(); err != nil {
 		return nil, err
 	}
 
-	return &cfg, nil
+	return cfg, nil
 }
 
 func (c *ClientConfig) Validate() error {
+	var errs ValidationErrors
+	add := func(path, file, format string, args ...any) {
+		errs = append(errs, ValidationError{Path: path, Message: fmt.Sprintf(format, args...), File: file})
+	}
+
+	for _, e := range c.unknown {
+		if e.File == c.source {
+			e.File = ""
+		}
+		errs = append(errs, e)
+	}
+
 	if len(c.Hosts) == 0 {
-		return fmt.Errorf("config: no hosts defined")
+		add("hosts", "", "no hosts defined")
 	}
 	if len(c.Tasks) == 0 {
-		return fmt.Errorf("config: no tasks defined")
+		add("tasks", "", "no tasks defined")
+	}
+	for _, e := range c.Backup.validationErrors() {
+		e.File = c.fileOf("section", "backup")
+		errs = append(errs, e)
+	}
+	normalizeFile := c.fileOf("section", "normalize")
+	if err := ValidateNormalizeFixes(c.Normalize.Fixes); err != nil {
+		add("normalize.fixes", normalizeFile, "%v", err)
+	}
+	if c.Normalize.TabWidth < 0 {
+		add("normalize.tab_width", normalizeFile, "tab_width must not be negative")
 	}
 
-	for name, host := range c.Hosts {
+	for _, name := range sortedKeys(c.Hosts) {
+		host := c.Hosts[name]
+		path, file := "hosts."+name, c.fileOf("host", name)
+		if name == "all" {
+			add(path, file, "host alias \"all\" is reserved")
+		}
 		if host.Host == "" {
-			return fmt.Errorf("config: host %q missing host address", name)
+			add(path+".host", file, "missing host address")
 		}
 		if host.User == "" {
-			return fmt.Errorf("config: host %q missing user", name)
+			add(path+".user", file, "missing user")
 		}
 		if host.Key == "" && host.Password == "" {
-			return fmt.Errorf("config: host %q missing key or password", name)
+			add(path, file, "missing key or password")
+		}
+		if host.ScriptDir != "" && !strings.HasPrefix(host.ScriptDir, "/") {
+			add(path+".script_dir", file, "script_dir %q must be an absolute path", host.ScriptDir)
+		}
+		if err := ValidateLintRules(host.LintIgnore); err != nil {
+			add(path+".lint_ignore", file, "%v", err)
 		}
 	}
 
-	for name, steps := range c.Tasks {
+	for _, name := range sortedKeys(c.Groups) {
+		path, file := "groups."+name, c.fileOf("group", name)
+		if name == "all" {
+			add(path, file, "group name \"all\" is reserved")
+			continue
+		}
+		if _, ok := c.Hosts[name]; ok {
+			add(path, file, "group name %q clashes with a host alias", name)
+			continue
+		}
+		if len(c.Groups[name]) == 0 {
+			add(path, file, "group has no members")
+		}
+		for i, member := range c.Groups[name] {
+			if _, err := c.resolveTargetTerm(member, []string{name}, false); err != nil {
+				add(fmt.Sprintf("%s[%d]", path, i), file, "%v", err)
+			}
+		}
+	}
+
+	for _, name := range sortedKeys(c.Tasks) {
+		task, _ := c.task(name)
+		steps := task.Steps
+		path, file := "tasks."+name, c.fileOf("task", name)
+		stepsPath := c.taskStepsPath(name)
 		if len(steps) == 0 {
-			return fmt.Errorf("config: task %q has no steps", name)
+			add(path, file, "task has no steps")
+		}
+		if err := ValidateLintRules(task.LintIgnore); err != nil {
+			add(path+".lint_ignore", file, "%v", err)
+		}
+		if _, err := c.ResolveTargets(task.AllowedHosts); err != nil {
+			add(path+".allowed_hosts", file, "%v", err)
+		} else if len(task.Hosts) > 0 {
+			if _, err := c.TaskTargets(name, nil); err != nil {
+				add(path+".hosts", file, "%v", err)
+			}
+		}
+		if cycle := c.taskCallCycle(name, nil); cycle != nil && cycle[0] == name {
+			add(path, file, "task cycle: %s", strings.Join(cycle, " -> "))
 		}
 		for i, step := range steps {
-			switch step.Type {
-			case "file":
-				if step.Local == "" {
-					return fmt.Errorf("config: task %q step[%d] missing local path", name, i)
-				}
-				if step.Remote == "" {
-					return fmt.Errorf("config: task %q step[%d] missing remote path", name, i)
-				}
-			case "exec":
-				if step.Run == "" {
-					return fmt.Errorf("config: task %q step[%d] missing run command", name, i)
+			errs = append(errs, c.validateStep(task, fmt.Sprintf("%s[%d]", stepsPath, i), file, step)...)
+		}
+		for i, step := range task.OnFailure {
+			errs = append(errs, c.validateStep(task, fmt.Sprintf("%s.on_failure[%d]", path, i), file, step)...)
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	sortValidationErrors(errs)
+	return errs
+}
+
+func (c *ClientConfig) validateStep(task Task, stepPath, file string, step TaskStep) ValidationErrors {
+	var errs ValidationErrors
+	add := func(path, format string, args ...any) {
+		errs = append(errs, ValidationError{Path: path, Message: fmt.Sprintf(format, args...), File: file})
+	}
+
+	if len(task.Params) > 0 {
+		for _, ref := range stepParamRefs(step) {
+			if _, ok := task.Params[ref]; !ok {
+				add(stepPath, "undefined parameter {{%s}}", ref)
+			}
+		}
+	}
+	for _, cond := range []struct {
+		key  string
+		cond *Condition
+	}{{"when", step.When}, {"unless", step.Unless}, {"retry_until", step.RetryUntil}} {
+		if cond.cond == nil {
+			continue
+		}
+		if field, err := cond.cond.validate(); err != nil {
+			add(joinConfigPath(stepPath+"."+cond.key, field), "%v", err)
+		}
+	}
+	switch step.Type {
+	case "file":
+		if step.Local == "" {
+			add(stepPath+".local", "missing local path")
+		}
+		if step.Remote == "" {
+			add(stepPath+".remote", "missing remote path")
+		}
+		if err := ValidateNormalizeMode(step.Normalize); err != nil {
+			add(stepPath+".normalize", "%v", err)
+		}
+		if err := ValidateNormalizeFixes(step.Fixes); err != nil {
+			add(stepPath+".fixes", "%v", err)
+		}
+		if step.Validate != "" && !strings.Contains(step.Validate, "%s") {
+			add(stepPath+".validate", "validate command must contain %%s for the staged file")
+		}
+		if step.Sudo {
+			add(stepPath+".sudo", "sudo is only supported on exec steps")
+		}
+	case "exec":
+		if step.Run == "" {
+			add(stepPath+".run", "missing run command")
+		}
+	case "fetch":
+		if step.Remote == "" {
+			add(stepPath+".remote", "missing remote path")
+		}
+		if step.Local == "" {
+			add(stepPath+".local", "missing local directory")
+		}
+	case "wait":
+		validateWaitStep(step, add, stepPath)
+	case "reboot":
+		validatePollDurations(step, add, stepPath)
+	case "script":
+		if step.Local == "" {
+			add(stepPath+".local", "missing local script path")
+		}
+		if err := ValidateNormalizeMode(step.Normalize); err != nil {
+			add(stepPath+".normalize", "%v", err)
+		}
+		if err := ValidateNormalizeFixes(step.Fixes); err != nil {
+			add(stepPath+".fixes", "%v", err)
+		}
+		if step.Validate != "" {
+			add(stepPath+".validate", "validate is only supported on file steps")
+		}
+	case "task":
+		callee, ok := c.task(step.Task)
+		switch {
+		case step.Task == "":
+			add(stepPath+".task", "missing task name")
+		case !ok:
+			add(stepPath+".task", "unknown task %q", step.Task)
+		default:
+			for _, k := range sortedKeys(step.Params) {
+				if _, ok := callee.Params[k]; !ok {
+					add(stepPath+".params."+k, "task %q has no parameter %q", step.Task, k)
 				}
-			default:
-				return fmt.Errorf("config: task %q step[%d] unknown type %q", name, i, step.Type)
 			}
 		}
+	case "":
+		add(stepPath+".type", "missing step type")
+	default:
+		add(stepPath+".type", "unknown type %q", step.Type)
+	}
+	if step.Retries < 0 {
+		add(stepPath+".retries", "retries must not be negative")
+	}
+	if step.RetryDelay != "" {
+		if _, err := time.ParseDuration(step.RetryDelay); err != nil {
+			add(stepPath+".retry_delay", "invalid duration %q", step.RetryDelay)
+		}
+	}
+	if step.Backoff != 0 && step.Backoff < 1 {
+		add(stepPath+".backoff", "backoff must be at least 1")
 	}
 
-	return nil
+	return errs
 }
 
 func (c *ClientConfig) ResolveHost(alias string) (ServerConfig, error) {
@@ -91,6 +402,18 @@ func (c *ClientConfig) ResolveHost(alias string) (ServerConfig, error) {
 	return host, nil
 }
 
+func (c *ClientConfig) normalizeOptions(mode string, fixes []string) NormalizeOptions {
+	if fixes == nil {
+		fixes = c.Normalize.Fixes
+	}
+	return NormalizeOptions{
+		Mode:             mode,
+		BinaryExtensions: c.Normalize.BinaryExtensions,
+		Fixes:            fixes,
+		TabWidth:         c.Normalize.TabWidth,
+	}
+}
+
 func (c *ClientConfig) ResolveTask(name string) ([]TaskStep, error) {
 	steps, ok := c.Tasks[name]
 	if !ok {
@@ -98,3 +421,14 @@ func (c *ClientConfig) ResolveTask(name string) ([]TaskStep, error) {
 	}
 	return steps, nil
 }
+
+func resolveConfigPath(configPath, p string) string {
+	if p == "" {
+		return ""
+	}
+	p = ExpandHome(p)
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(filepath.Dir(configPath), p)
+}
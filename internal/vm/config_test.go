@@ -67,6 +67,48 @@ func TestLoadClientConfig(t *testing.T) {
 		}
 	})
 
+	t.Run("backup dir relative to config", func(t *testing.T) {
+		path := filepath.Join(dir, "backup.json")
+		os.WriteFile(path, []byte(`{
+			"hosts": {
+				"prod": {"host": "10.0.0.1", "user": "admin", "password": "pass"}
+			},
+			"tasks": {
+				"test": [{"type": "exec", "run": "echo"}]
+			},
+			"backup": {"dir": "backups/acme", "mode": "both", "remote_dir": "/var/backups/acme"}
+		}`), 0644)
+
+		cfg, err := LoadClientConfig(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if want := filepath.Join(dir, "backups", "acme"); cfg.Backup.Dir != want {
+			t.Errorf("got backup dir %q, want %q", cfg.Backup.Dir, want)
+		}
+		if cfg.Backup.RemoteDir != "/var/backups/acme" {
+			t.Errorf("got remote dir %q, want %q", cfg.Backup.RemoteDir, "/var/backups/acme")
+		}
+	})
+
+	t.Run("invalid backup mode", func(t *testing.T) {
+		path := filepath.Join(dir, "bad-backup.json")
+		os.WriteFile(path, []byte(`{
+			"hosts": {
+				"prod": {"host": "10.0.0.1", "user": "admin", "password": "pass"}
+			},
+			"tasks": {
+				"test": [{"type": "exec", "run": "echo"}]
+			},
+			"backup": {"mode": "s3"}
+		}`), 0644)
+
+		_, err := LoadClientConfig(path)
+		if err == nil {
+			t.Fatal("expected error for invalid backup mode")
+		}
+	})
+
 	t.Run("missing file", func(t *testing.T) {
 		_, err := LoadClientConfig(filepath.Join(dir, "nope.json"))
 		if err == nil {
@@ -175,6 +217,36 @@ func TestValidateClientConfig(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "file step invalid normalize mode",
+			cfg: ClientConfig{
+				Hosts: validHost,
+				Tasks: map[string][]TaskStep{
+					"bad": {{Type: "file", Local: "/l", Remote: "/r", Normalize: "sometimes"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "file step unknown fix",
+			cfg: ClientConfig{
+				Hosts: validHost,
+				Tasks: map[string][]TaskStep{
+					"bad": {{Type: "file", Local: "/l", Remote: "/r", Fixes: []string{"trailing-whitespace"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "file step validate without placeholder",
+			cfg: ClientConfig{
+				Hosts: validHost,
+				Tasks: map[string][]TaskStep{
+					"bad": {{Type: "file", Local: "/l", Remote: "/r", Validate: "nginx -t"}},
+				},
+			},
+			wantErr: true,
+		},
 		{
 			name: "unknown step type",
 			cfg: ClientConfig{
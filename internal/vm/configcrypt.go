@@ -0,0 +1,198 @@
+package vm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var ErrConfigKeyMissing = errors.New("config is encrypted but no key is configured (set ONEVM_CONFIG_KEY, ONEVM_CONFIG_KEY_FILE or ONEVM_CONFIG_PASSPHRASE)")
+
+func configKey() (secretKey, error) {
+	if v := os.Getenv("ONEVM_CONFIG_KEY"); v != "" {
+		key, err := parseSecretKey(v)
+		if err != nil {
+			return secretKey{}, fmt.Errorf("ONEVM_CONFIG_KEY: %w", err)
+		}
+		return secretKey{key: key}, nil
+	}
+	if v := os.Getenv("ONEVM_CONFIG_KEY_FILE"); v != "" {
+		key, err := readSecretKeyFile(v)
+		if err != nil {
+			return secretKey{}, err
+		}
+		return secretKey{key: key}, nil
+	}
+	if v := os.Getenv("ONEVM_CONFIG_PASSPHRASE"); v != "" {
+		return secretKey{passphrase: []byte(v)}, nil
+	}
+	return secretKey{}, ErrConfigKeyMissing
+}
+
+func readConfigFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config %s: %w", path, err)
+	}
+	if !isEncrypted(data) {
+		return data, nil
+	}
+
+	key, err := configKey()
+	if err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	plain, err := decryptBytes(data, key)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting config %s: %w", path, err)
+	}
+	return plain, nil
+}
+
+func EncryptConfig(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config %s: %w", path, err)
+	}
+	if isEncrypted(data) {
+		return fmt.Errorf("config %s is already encrypted", path)
+	}
+	if err := checkConfigSyntax(path, data); err != nil {
+		return err
+	}
+
+	key, err := configKey()
+	if err != nil {
+		return err
+	}
+	return writeEncryptedConfig(path, data, key)
+}
+
+func DecryptConfig(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config %s: %w", path, err)
+	}
+	if !isEncrypted(data) {
+		return fmt.Errorf("config %s is not encrypted", path)
+	}
+
+	plain, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	return rewriteConfig(path, plain)
+}
+
+func EditConfig(path, editor string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config %s: %w", path, err)
+	}
+	if !isEncrypted(data) {
+		return fmt.Errorf("config %s is not encrypted", path)
+	}
+
+	key, err := configKey()
+	if err != nil {
+		return err
+	}
+	plain, err := decryptBytes(data, key)
+	if err != nil {
+		return fmt.Errorf("decrypting config %s: %w", path, err)
+	}
+
+	dir, err := os.MkdirTemp("", "onevm-edit-*")
+	if err != nil {
+		return fmt.Errorf("creating temp directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, filepath.Base(path))
+	if err := os.WriteFile(tmp, plain, 0600); err != nil {
+		return fmt.Errorf("writing temp config: %w", err)
+	}
+
+	if editor == "" {
+		editor = editorCommand()
+	}
+	args := strings.Fields(editor)
+	if len(args) == 0 {
+		return fmt.Errorf("no editor configured")
+	}
+	cmd := exec.Command(args[0], append(args[1:], tmp)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("running editor: %w", err)
+	}
+
+	edited, err := os.ReadFile(tmp)
+	if err != nil {
+		return fmt.Errorf("reading edited config: %w", err)
+	}
+	if bytes.Equal(edited, plain) {
+		return nil
+	}
+	if err := checkConfigSyntax(path, edited); err != nil {
+		return fmt.Errorf("edited config is invalid, %s left unchanged: %w", path, err)
+	}
+
+	return writeEncryptedConfig(path, edited, key)
+}
+
+func editorCommand() string {
+	for _, env := range []string{"VISUAL", "EDITOR"} {
+		if v := os.Getenv(env); v != "" {
+			return v
+		}
+	}
+	return "vi"
+}
+
+func checkConfigSyntax(path string, data []byte) error {
+	var v any
+	if err := decodeConfig(path, data, &v); err != nil {
+		return fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	return nil
+}
+
+func writeEncryptedConfig(path string, data []byte, key secretKey) error {
+	var buf bytes.Buffer
+	w, err := newEncryptWriter(&buf, key)
+	if err != nil {
+		return fmt.Errorf("encrypting config: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("encrypting config: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("encrypting config: %w", err)
+	}
+	return rewriteConfig(path, buf.Bytes())
+}
+
+// rewriteConfig replaces path atomically, keeping the mode of the existing
+// file so encrypting or editing a config doesn't change who can read it.
+func rewriteConfig(path string, data []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("reading config %s: %w", path, err)
+	}
+	return writeFileAtomic(path, data, info.Mode().Perm())
+}
+
+func decryptBytes(data []byte, key secretKey) ([]byte, error) {
+	r, err := newDecryptReader(bytes.NewReader(data), key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
@@ -0,0 +1,120 @@
+package vm
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testClientConfig = `{
+	"hosts": {"prod": {"host": "10.0.0.1", "user": "admin", "password": "secret"}},
+	"tasks": {"uptime": [{"type": "exec", "run": "uptime"}]}
+}`
+
+func TestEncryptConfigRoundTrip(t *testing.T) {
+	t.Setenv("ONEVM_CONFIG_PASSPHRASE", "correct horse")
+	path := filepath.Join(t.TempDir(), "acme.json")
+	os.WriteFile(path, []byte(testClientConfig), 0640)
+	os.Chmod(path, 0640)
+
+	if err := EncryptConfig(path); err != nil {
+		t.Fatalf("EncryptConfig() error: %v", err)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0640 {
+		t.Errorf("mode changed to %v", info.Mode().Perm())
+	}
+	data, _ := os.ReadFile(path)
+	if !isEncrypted(data) || strings.Contains(string(data), "secret") {
+		t.Fatal("config not encrypted")
+	}
+	if err := EncryptConfig(path); err == nil {
+		t.Error("expected error encrypting twice")
+	}
+
+	cfg, err := LoadClientConfig(path)
+	if err != nil {
+		t.Fatalf("LoadClientConfig() error: %v", err)
+	}
+	if cfg.Hosts["prod"].Password != "secret" {
+		t.Errorf("unexpected password %q", cfg.Hosts["prod"].Password)
+	}
+
+	if err := DecryptConfig(path); err != nil {
+		t.Fatalf("DecryptConfig() error: %v", err)
+	}
+	data, _ = os.ReadFile(path)
+	if string(data) != testClientConfig {
+		t.Errorf("decrypted config differs:\n%s", data)
+	}
+}
+
+func TestLoadEncryptedConfigErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acme.json")
+	os.WriteFile(path, []byte(testClientConfig), 0644)
+
+	t.Setenv("ONEVM_CONFIG_PASSPHRASE", "right")
+	if err := EncryptConfig(path); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("ONEVM_CONFIG_PASSPHRASE", "wrong")
+	if _, err := LoadClientConfig(path); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("wrong passphrase: got %v, want ErrDecrypt", err)
+	}
+
+	t.Setenv("ONEVM_CONFIG_PASSPHRASE", "")
+	if _, err := LoadClientConfig(path); !errors.Is(err, ErrConfigKeyMissing) {
+		t.Errorf("no key: got %v, want ErrConfigKeyMissing", err)
+	}
+}
+
+func TestEditConfig(t *testing.T) {
+	t.Setenv("ONEVM_CONFIG_PASSPHRASE", "edit")
+	path := filepath.Join(t.TempDir(), "acme.json")
+	os.WriteFile(path, []byte(testClientConfig), 0644)
+	if err := EncryptConfig(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := EditConfig(path, "sed -i s/10.0.0.1/10.0.0.2/"); err != nil {
+		t.Fatalf("EditConfig() error: %v", err)
+	}
+	cfg, err := LoadClientConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Hosts["prod"].Host != "10.0.0.2" {
+		t.Errorf("edit not applied: %q", cfg.Hosts["prod"].Host)
+	}
+
+	before, _ := os.ReadFile(path)
+	if err := EditConfig(path, "sed -i s/{/[/"); err == nil {
+		t.Error("expected error for invalid edit")
+	}
+	after, _ := os.ReadFile(path)
+	if string(before) != string(after) {
+		t.Error("invalid edit modified the config")
+	}
+}
+
+func TestLoadEncryptedManifest(t *testing.T) {
+	t.Setenv("ONEVM_CONFIG_PASSPHRASE", "manifest")
+	path := filepath.Join(t.TempDir(), "manifest.json")
+	os.WriteFile(path, []byte(`{
+		"servers": [{"host": "10.0.0.1", "user": "admin", "password": "secret"}],
+		"files": [{"local": "./app.conf", "remote": "/etc/app.conf"}]
+	}`), 0600)
+	if err := EncryptConfig(path); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := LoadManifest(path)
+	if err != nil {
+		t.Fatalf("LoadManifest() error: %v", err)
+	}
+	if m.Servers[0].Password != "secret" {
+		t.Errorf("unexpected password %q", m.Servers[0].Password)
+	}
+}
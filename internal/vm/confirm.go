@@ -0,0 +1,80 @@
+package vm
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+var (
+	ErrConfirmationRequired = errors.New("protected host requires confirmation (run interactively or pass --yes)")
+	ErrNotConfirmed         = errors.New("confirmation failed")
+)
+
+type Confirmation struct {
+	Yes         bool
+	Interactive bool
+	In          io.Reader
+	Out         io.Writer
+}
+
+func TerminalConfirmation(yes bool) Confirmation {
+	return Confirmation{
+		Yes:         yes,
+		Interactive: isTerminal(os.Stdin),
+		In:          os.Stdin,
+		Out:         os.Stderr,
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+func (c *ClientConfig) ConfirmProtected(aliases []string, action string, plan []string, conf Confirmation) error {
+	servers := make(map[string]ServerConfig, len(aliases))
+	for _, alias := range aliases {
+		servers[alias] = c.Hosts[alias]
+	}
+	return confirmProtected(aliases, servers, action, plan, conf)
+}
+
+func confirmProtected(names []string, servers map[string]ServerConfig, action string, plan []string, conf Confirmation) error {
+	var protected []string
+	for _, name := range names {
+		if servers[name].Protected {
+			protected = append(protected, name)
+		}
+	}
+	if len(protected) == 0 || conf.Yes {
+		return nil
+	}
+	if !conf.Interactive || conf.In == nil || conf.Out == nil {
+		return fmt.Errorf("%s: %w", strings.Join(protected, ", "), ErrConfirmationRequired)
+	}
+
+	in := bufio.NewReader(conf.In)
+	for _, name := range protected {
+		fmt.Fprintf(conf.Out, "\n%s on PROTECTED host %s (%s):\n", action, name, servers[name].Host)
+		for _, step := range plan {
+			fmt.Fprintf(conf.Out, "  - %s\n", step)
+		}
+		fmt.Fprintf(conf.Out, "Type %q to continue: ", name)
+
+		line, err := in.ReadString('\n')
+		if strings.TrimSpace(line) != name {
+			if err != nil && err != io.EOF {
+				return fmt.Errorf("reading confirmation: %w", err)
+			}
+			return fmt.Errorf("%s: %w", name, ErrNotConfirmed)
+		}
+	}
+	return nil
+}
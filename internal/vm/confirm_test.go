@@ -0,0 +1,95 @@
+package vm
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfirmProtected(t *testing.T) {
+	cfg := &ClientConfig{Hosts: map[string]ServerConfig{
+		"dev":  {Host: "10.0.0.2"},
+		"prod": {Host: "10.0.0.1", Protected: true},
+	}}
+	plan := []string{"file:/etc/nginx/nginx.conf", "exec:systemctl reload nginx"}
+
+	tests := []struct {
+		name    string
+		aliases []string
+		conf    Confirmation
+		input   string
+		wantErr error
+	}{
+		{name: "unprotected", aliases: []string{"dev"}},
+		{name: "yes flag", aliases: []string{"prod"}, conf: Confirmation{Yes: true}},
+		{name: "non-interactive", aliases: []string{"dev", "prod"}, wantErr: ErrConfirmationRequired},
+		{name: "confirmed", aliases: []string{"prod"}, conf: Confirmation{Interactive: true}, input: "prod\n"},
+		{name: "wrong alias", aliases: []string{"prod"}, conf: Confirmation{Interactive: true}, input: "dev\n", wantErr: ErrNotConfirmed},
+		{name: "eof", aliases: []string{"prod"}, conf: Confirmation{Interactive: true}, input: "", wantErr: ErrNotConfirmed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if tt.conf.Interactive {
+				tt.conf.In = strings.NewReader(tt.input)
+				tt.conf.Out = &out
+			}
+
+			err := cfg.ConfirmProtected(tt.aliases, "run deploy", plan, tt.conf)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ConfirmProtected() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.conf.Interactive && !strings.Contains(out.String(), "exec:systemctl reload nginx") {
+				t.Errorf("summary missing planned steps:\n%s", out.String())
+			}
+		})
+	}
+}
+
+func TestExecuteRunRequiresConfirmation(t *testing.T) {
+	cfg := &ClientConfig{
+		Hosts: map[string]ServerConfig{"prod": {Host: "10.0.0.1", User: "u", Key: "k", Protected: true}},
+		Tasks: map[string][]TaskStep{"deploy": {{Type: "exec", Run: "true"}}},
+	}
+
+	results := ExecuteRun(cfg, "deploy", []string{"prod"}, false, Confirmation{})
+	if len(results) != 1 || results[0].Status != "error" || !strings.Contains(results[0].Steps[0].Error, "--yes") {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+
+	results = ExecuteRun(cfg, "deploy", []string{"prod"}, true, Confirmation{})
+	if results[0].Status != "dry-run" {
+		t.Errorf("dry run should not need confirmation: %+v", results)
+	}
+}
+
+func TestWritePathsConfirmBeforeSecrets(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "resolved")
+	prod := ServerConfig{Host: "10.0.0.1", User: "u", Password: "cmd:touch " + marker + "; echo pw", Protected: true}
+	cfg := &ClientConfig{Hosts: map[string]ServerConfig{"prod": prod}}
+
+	pushed := ExecutePush(cfg, []string{"prod"}, "./f.conf", "/etc/f.conf", false, Confirmation{})
+	if len(pushed) != 1 || !strings.Contains(pushed[0].Error, "--yes") {
+		t.Errorf("push results = %+v", pushed)
+	}
+	rolled := ExecuteRollback(cfg, []string{"prod"}, "/etc/f.conf", Confirmation{})
+	if len(rolled) != 1 || !strings.Contains(rolled[0].Error, "--yes") {
+		t.Errorf("rollback results = %+v", rolled)
+	}
+	direct := ExecuteRollbackDirect(prod, BackupConfig{}, "/etc/f.conf", Confirmation{})
+	if !strings.Contains(direct.Error, "--yes") {
+		t.Errorf("direct rollback result = %+v", direct)
+	}
+	deployed := ExecuteDeploy(&Manifest{Servers: []ServerConfig{prod}, Files: []FileConfig{{Local: "a", Remote: "/a"}}}, false, Confirmation{})
+	if len(deployed) != 1 || !strings.Contains(deployed[0].Error, "--yes") {
+		t.Errorf("deploy results = %+v", deployed)
+	}
+
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("cmd: secret was resolved before confirmation")
+	}
+}
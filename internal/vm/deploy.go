@@ -3,16 +3,39 @@ package vm
 import "fmt"
 
 type DeployResult struct {
-	Server string `json:"server"`
-	File   string `json:"file"`
-	Status string `json:"status"`
-	Backup string `json:"backup,omitempty"`
-	Error  string `json:"error,omitempty"`
+	Server       string `json:"server"`
+	File         string `json:"file"`
+	Status       string `json:"status"`
+	Backup       string `json:"backup,omitempty"`
+	RemoteBackup string `json:"remote_backup,omitempty"`
+	Normalize    string `json:"normalize,omitempty"`
+	Error        string `json:"error,omitempty"`
 }
 
-func ExecuteDeploy(m *Manifest, dryRun bool) []DeployResult {
+func ExecuteDeploy(m *Manifest, dryRun bool, confirm Confirmation) []DeployResult {
 	var results []DeployResult
 
+	if !dryRun {
+		names := make([]string, len(m.Servers))
+		servers := make(map[string]ServerConfig, len(m.Servers))
+		for i, server := range m.Servers {
+			names[i] = server.Host
+			servers[server.Host] = server
+		}
+		var plan []string
+		for _, file := range m.Files {
+			plan = append(plan, fmt.Sprintf("upload %s to %s", file.Local, file.Remote))
+		}
+		if err := confirmProtected(names, servers, "deploy", plan, confirm); err != nil {
+			for _, server := range m.Servers {
+				for _, file := range m.Files {
+					results = append(results, DeployResult{Server: server.Host, File: file.Remote, Status: "error", Error: err.Error()})
+				}
+			}
+			return results
+		}
+	}
+
 	for _, server := range m.Servers {
 		if dryRun {
 			for _, file := range m.Files {
@@ -25,8 +48,7 @@ func ExecuteDeploy(m *Manifest, dryRun bool) []DeployResult {
 			continue
 		}
 
-		auth := SSHAuth{KeyPath: ExpandHome(server.Key), Password: server.Password}
-		client, err := NewSSHClient(server.Host, server.User, auth)
+		client, err := server.connect()
 		if err != nil {
 			for _, file := range m.Files {
 				results = append(results, DeployResult{
@@ -54,7 +76,7 @@ func ExecuteDeploy(m *Manifest, dryRun bool) []DeployResult {
 		}
 
 		for _, file := range m.Files {
-			result := deploySingleFile(client, transfer, server, file)
+			result := deploySingleFile(client, transfer, server, file, m.Backup)
 			results = append(results, result)
 		}
 
@@ -65,26 +87,34 @@ func ExecuteDeploy(m *Manifest, dryRun bool) []DeployResult {
 	return results
 }
 
-func deploySingleFile(client *SSHClient, transfer *SFTPTransfer, server ServerConfig, file FileConfig) DeployResult {
+func deploySingleFile(client *SSHClient, transfer *SFTPTransfer, server ServerConfig, file FileConfig, backup BackupConfig) DeployResult {
 	result := DeployResult{
 		Server: server.Host,
 		File:   file.Remote,
 	}
 
-	backupPath, err := CreateBackup(transfer, file.Remote, server.Host)
+	normalized, report, err := NormalizeFileWith(file.Local, NormalizeOptions{})
 	if err != nil {
 		result.Status = "error"
-		result.Error = fmt.Sprintf("backup failed (aborting): %v", err)
+		result.Error = fmt.Sprintf("normalization failed: %v", err)
 		return result
 	}
-	result.Backup = backupPath
+	result.Normalize = report.String()
 
-	normalized, err := NormalizeFile(file.Local)
+	if err := CheckSyntax(file.Local, normalized); err != nil {
+		result.Status = "error"
+		result.Error = fmt.Sprintf("validation failed: %v", err)
+		return result
+	}
+
+	record, err := CreateBackup(transfer, file.Remote, server.Host, backup)
 	if err != nil {
 		result.Status = "error"
-		result.Error = fmt.Sprintf("normalization failed: %v", err)
+		result.Error = fmt.Sprintf("backup failed (aborting): %v", err)
 		return result
 	}
+	result.Backup = record.Local
+	result.RemoteBackup = record.Remote
 
 	if err := transfer.UploadBytes(normalized, file.Remote); err != nil {
 		result.Status = "error"
@@ -0,0 +1,272 @@
+package vm
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	encMagic       = "ONEVM-ENC1"
+	encKDFRaw      = 0
+	encKDFScrypt   = 1
+	encSaltSize    = 16
+	encPrefixSize  = 16
+	encChunkSize   = 64 * 1024
+	encFinalFlag   = 1 << 31
+	scryptN        = 1 << 15
+	scryptR        = 8
+	scryptP        = 1
+	secretKeyBytes = 32
+)
+
+var ErrDecrypt = errors.New("decryption failed (wrong key or corrupted data)")
+
+type secretKey struct {
+	key        *[secretKeyBytes]byte
+	passphrase []byte
+}
+
+func (k secretKey) empty() bool {
+	return k.key == nil && len(k.passphrase) == 0
+}
+
+func parseSecretKey(s string) (*[secretKeyBytes]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("key is not valid base64: %w", err)
+	}
+	if len(raw) != secretKeyBytes {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", secretKeyBytes, len(raw))
+	}
+	var key [secretKeyBytes]byte
+	copy(key[:], raw)
+	return &key, nil
+}
+
+func readSecretKeyFile(path string) (*[secretKeyBytes]byte, error) {
+	data, err := os.ReadFile(ExpandHome(path))
+	if err != nil {
+		return nil, fmt.Errorf("reading key file %s: %w", path, err)
+	}
+	if len(data) == secretKeyBytes {
+		var key [secretKeyBytes]byte
+		copy(key[:], data)
+		return &key, nil
+	}
+	key, err := parseSecretKey(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("key file %s: %w", path, err)
+	}
+	return key, nil
+}
+
+func deriveKey(passphrase, salt []byte) (*[secretKeyBytes]byte, error) {
+	derived, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, secretKeyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("deriving key: %w", err)
+	}
+	var key [secretKeyBytes]byte
+	copy(key[:], derived)
+	return &key, nil
+}
+
+func chunkNonce(prefix []byte, counter uint64, final bool) *[24]byte {
+	var nonce [24]byte
+	copy(nonce[:encPrefixSize], prefix)
+	binary.BigEndian.PutUint64(nonce[encPrefixSize:], counter)
+	if final {
+		nonce[encPrefixSize] |= 0x80
+	}
+	return &nonce
+}
+
+type encryptWriter struct {
+	w       io.Writer
+	key     *[secretKeyBytes]byte
+	prefix  []byte
+	counter uint64
+	buf     []byte
+}
+
+func newEncryptWriter(w io.Writer, k secretKey) (io.WriteCloser, error) {
+	if k.empty() {
+		return nil, errors.New("no encryption key configured")
+	}
+
+	header := []byte(encMagic)
+	key := k.key
+	if key == nil {
+		salt := make([]byte, encSaltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, fmt.Errorf("generating salt: %w", err)
+		}
+		derived, err := deriveKey(k.passphrase, salt)
+		if err != nil {
+			return nil, err
+		}
+		key = derived
+		header = append(header, encKDFScrypt)
+		header = append(header, salt...)
+	} else {
+		header = append(header, encKDFRaw)
+	}
+
+	prefix := make([]byte, encPrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, fmt.Errorf("generating nonce: %w", err)
+	}
+	header = append(header, prefix...)
+
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &encryptWriter{w: w, key: key, prefix: prefix}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	e.buf = append(e.buf, p...)
+	for len(e.buf) > encChunkSize {
+		if err := e.seal(e.buf[:encChunkSize], false); err != nil {
+			return 0, err
+		}
+		e.buf = e.buf[encChunkSize:]
+	}
+	return len(p), nil
+}
+
+func (e *encryptWriter) Close() error {
+	return e.seal(e.buf, true)
+}
+
+func (e *encryptWriter) seal(chunk []byte, final bool) error {
+	sealed := secretbox.Seal(nil, chunk, chunkNonce(e.prefix, e.counter, final), e.key)
+	e.counter++
+
+	length := uint32(len(sealed))
+	if final {
+		length |= encFinalFlag
+	}
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], length)
+
+	if _, err := e.w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+type decryptReader struct {
+	r       io.Reader
+	key     *[secretKeyBytes]byte
+	prefix  []byte
+	counter uint64
+	buf     []byte
+	done    bool
+}
+
+func isEncrypted(header []byte) bool {
+	return bytes.HasPrefix(header, []byte(encMagic))
+}
+
+func newDecryptReader(r io.Reader, k secretKey) (io.Reader, error) {
+	magic := make([]byte, len(encMagic)+1)
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, fmt.Errorf("reading encryption header: %w", err)
+	}
+	if !isEncrypted(magic) {
+		return nil, errors.New("data is not encrypted by onevm")
+	}
+	if k.empty() {
+		return nil, errors.New("data is encrypted but no key is configured")
+	}
+
+	key := k.key
+	switch magic[len(encMagic)] {
+	case encKDFRaw:
+		if key == nil {
+			return nil, errors.New("data is encrypted with a key, not a passphrase")
+		}
+	case encKDFScrypt:
+		if len(k.passphrase) == 0 {
+			return nil, errors.New("data is encrypted with a passphrase, not a key")
+		}
+		salt := make([]byte, encSaltSize)
+		if _, err := io.ReadFull(r, salt); err != nil {
+			return nil, fmt.Errorf("reading encryption header: %w", err)
+		}
+		derived, err := deriveKey(k.passphrase, salt)
+		if err != nil {
+			return nil, err
+		}
+		key = derived
+	default:
+		return nil, fmt.Errorf("unknown key derivation %d", magic[len(encMagic)])
+	}
+
+	prefix := make([]byte, encPrefixSize)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, fmt.Errorf("reading encryption header: %w", err)
+	}
+
+	return &decryptReader{r: r, key: key, prefix: prefix}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+func (d *decryptReader) open() error {
+	var hdr [4]byte
+	if _, err := io.ReadFull(d.r, hdr[:]); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	length := binary.BigEndian.Uint32(hdr[:])
+	final := length&encFinalFlag != 0
+	length &^= encFinalFlag
+	if length > encChunkSize+secretbox.Overhead {
+		return ErrDecrypt
+	}
+
+	sealed := make([]byte, length)
+	if _, err := io.ReadFull(d.r, sealed); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	plain, ok := secretbox.Open(nil, sealed, chunkNonce(d.prefix, d.counter, final), d.key)
+	if !ok {
+		return ErrDecrypt
+	}
+	d.counter++
+	d.buf = plain
+	d.done = final
+	return nil
+}
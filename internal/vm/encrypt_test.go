@@ -0,0 +1,105 @@
+package vm
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	var raw [secretKeyBytes]byte
+	rand.Read(raw[:])
+	key := secretKey{key: &raw}
+
+	large := make([]byte, encChunkSize*2+123)
+	rand.Read(large)
+
+	tests := []struct {
+		name  string
+		key   secretKey
+		input []byte
+	}{
+		{name: "empty", key: key, input: nil},
+		{name: "small", key: key, input: []byte("ssl_certificate_key /etc/ssl/key.pem;")},
+		{name: "exact chunk", key: key, input: large[:encChunkSize]},
+		{name: "multiple chunks", key: key, input: large},
+		{name: "passphrase", key: secretKey{passphrase: []byte("s3cret")}, input: []byte("hello")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := newEncryptWriter(&buf, tt.key)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			w.Write(tt.input)
+			if err := w.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			r, err := newDecryptReader(bytes.NewReader(buf.Bytes()), tt.key)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !bytes.Equal(got, tt.input) {
+				t.Error("decrypted content does not match original")
+			}
+		})
+	}
+}
+
+func TestDecrypt_Failures(t *testing.T) {
+	var raw, other [secretKeyBytes]byte
+	rand.Read(raw[:])
+	rand.Read(other[:])
+
+	var buf bytes.Buffer
+	w, _ := newEncryptWriter(&buf, secretKey{key: &raw})
+	w.Write(make([]byte, encChunkSize+10))
+	w.Close()
+	sealed := buf.Bytes()
+
+	t.Run("wrong key", func(t *testing.T) {
+		r, err := newDecryptReader(bytes.NewReader(sealed), secretKey{key: &other})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := io.ReadAll(r); !errors.Is(err, ErrDecrypt) {
+			t.Fatalf("got error %v, want ErrDecrypt", err)
+		}
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		r, err := newDecryptReader(bytes.NewReader(sealed[:len(sealed)-20]), secretKey{key: &raw})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := io.ReadAll(r); err == nil {
+			t.Fatal("expected error for truncated data")
+		}
+	})
+
+	t.Run("missing final chunk", func(t *testing.T) {
+		cut := len(encMagic) + 1 + encPrefixSize + 4 + encChunkSize + 16
+		r, err := newDecryptReader(bytes.NewReader(sealed[:cut]), secretKey{key: &raw})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := io.ReadAll(r); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("got error %v, want ErrUnexpectedEOF", err)
+		}
+	})
+
+	t.Run("no key", func(t *testing.T) {
+		if _, err := newDecryptReader(bytes.NewReader(sealed), secretKey{}); err == nil {
+			t.Fatal("expected error without key")
+		}
+	})
+}
@@ -9,9 +9,20 @@ type ExecResult struct {
 	Error  string `json:"error,omitempty"`
 }
 
-func ExecuteExec(cfg *ClientConfig, aliases []string, command string) []ExecResult {
+func ExecuteExec(cfg *ClientConfig, targets []string, command string, confirm Confirmation) []ExecResult {
 	var results []ExecResult
 
+	aliases, err := cfg.ResolveTargets(targets)
+	if err == nil {
+		err = cfg.ConfirmProtected(aliases, "exec", []string{command}, confirm)
+	}
+	if err != nil {
+		for _, target := range targets {
+			results = append(results, ExecResult{Server: target, Status: "error", Error: err.Error()})
+		}
+		return results
+	}
+
 	for _, alias := range aliases {
 		result := ExecResult{Server: alias}
 
@@ -23,8 +34,7 @@ func ExecuteExec(cfg *ClientConfig, aliases []string, command string) []ExecResu
 			continue
 		}
 
-		auth := SSHAuth{KeyPath: server.Key, Password: server.Password}
-		client, err := NewSSHClient(server.Host, server.User, auth)
+		client, err := server.connect()
 		if err != nil {
 			result.Status = "error"
 			result.Error = fmt.Sprintf("connection failed: %v", err)
@@ -35,10 +45,10 @@ func ExecuteExec(cfg *ClientConfig, aliases []string, command string) []ExecResu
 		output, err := client.Execute(command)
 		client.Close()
 
-		result.Output = output
+		result.Output = server.redact(output)
 		if err != nil {
 			result.Status = "error"
-			result.Error = fmt.Sprintf("command failed: %v", err)
+			result.Error = server.redact(fmt.Sprintf("command failed: %v", err))
 		} else {
 			result.Status = "ok"
 		}
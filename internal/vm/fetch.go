@@ -0,0 +1,173 @@
+package vm
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+type PullResult struct {
+	Server string   `json:"server"`
+	Remote string   `json:"remote"`
+	Status string   `json:"status"`
+	Files  []string `json:"files,omitempty"`
+	Error  string   `json:"error,omitempty"`
+}
+
+func ParseRemoteSpec(spec string) (string, string, error) {
+	i := strings.Index(spec, ":/")
+	if i <= 0 {
+		return "", "", fmt.Errorf("invalid remote %q: expected <target>:/absolute/path", spec)
+	}
+	return spec[:i], spec[i+1:], nil
+}
+
+func ExecutePull(cfg *ClientConfig, targets []string, remotePattern, localDir string, recursive bool) []PullResult {
+	var results []PullResult
+
+	aliases, err := cfg.ResolveTargets(targets)
+	if err != nil {
+		for _, target := range targets {
+			results = append(results, PullResult{Server: target, Remote: remotePattern, Status: "error", Error: err.Error()})
+		}
+		return results
+	}
+
+	for _, alias := range aliases {
+		results = append(results, pullFromServer(cfg, alias, remotePattern, fetchDestination(localDir, alias, len(aliases) > 1), recursive))
+	}
+
+	return results
+}
+
+func pullFromServer(cfg *ClientConfig, alias, remotePattern, localDir string, recursive bool) PullResult {
+	result := PullResult{Server: alias, Remote: remotePattern}
+
+	server, err := cfg.ResolveHost(alias)
+	if err != nil {
+		result.Status = "error"
+		result.Error = err.Error()
+		return result
+	}
+
+	client, err := server.connect()
+	if err != nil {
+		result.Status = "error"
+		result.Error = fmt.Sprintf("connection failed: %v", err)
+		return result
+	}
+	defer client.Close()
+
+	transfer, err := NewSFTPTransfer(client)
+	if err != nil {
+		result.Status = "error"
+		result.Error = fmt.Sprintf("SFTP failed: %v", err)
+		return result
+	}
+	defer transfer.Close()
+
+	result.Files, err = fetchFiles(transfer, remotePattern, localDir, recursive)
+	if err != nil {
+		result.Status = "error"
+		result.Error = fmt.Sprintf("fetch failed: %v", err)
+		return result
+	}
+
+	result.Status = "ok"
+	return result
+}
+
+func fetchDestination(localDir, alias string, perHost bool) string {
+	if perHost {
+		return filepath.Join(localDir, safeDirName(alias))
+	}
+	return localDir
+}
+
+// safeDirName turns a host alias into a single path element that cannot
+// escape the directory it is joined to.
+func safeDirName(name string) string {
+	safe := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, name)
+	if strings.Trim(safe, ".") == "" {
+		safe = strings.Repeat("_", len(safe)+1)
+	}
+	return safe
+}
+
+// globBase returns the directory part of a remote pattern before the first
+// element containing a wildcard; for a plain path it is the parent directory.
+func globBase(pattern string) string {
+	base := path.Dir(pattern)
+	for strings.ContainsAny(base, "*?[") {
+		base = path.Dir(base)
+	}
+	return base
+}
+
+func fetchFiles(transfer *SFTPTransfer, remotePattern, localDir string, recursive bool) ([]string, error) {
+	matches := []string{remotePattern}
+	if strings.ContainsAny(remotePattern, "*?[") {
+		var err error
+		matches, err = transfer.Glob(remotePattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no remote files match %s", remotePattern)
+		}
+	}
+
+	base := globBase(remotePattern)
+	var files []string
+	for _, remote := range matches {
+		dir, err := transfer.IsDir(remote)
+		if err != nil {
+			return files, err
+		}
+
+		rel, err := localRel(base, remote)
+		if err != nil {
+			return files, err
+		}
+		dest := filepath.Join(localDir, rel)
+		if dir {
+			if !recursive {
+				return files, fmt.Errorf("%s is a directory (use recursive)", remote)
+			}
+			downloaded, err := transfer.DownloadDir(remote, dest)
+			files = append(files, downloaded...)
+			if err != nil {
+				return files, err
+			}
+			continue
+		}
+
+		if err := transfer.Download(remote, dest); err != nil {
+			return files, err
+		}
+		files = append(files, dest)
+	}
+	return files, nil
+}
+
+func executeFetchStep(transfer *SFTPTransfer, step TaskStep, localDir string) StepResult {
+	sr := StepResult{Step: stepLabel(step)}
+
+	files, err := fetchFiles(transfer, step.Remote, localDir, step.Recursive)
+	sr.Files = files
+	if err != nil {
+		sr.Status = "error"
+		sr.Error = fmt.Sprintf("fetch failed: %v", err)
+		return sr
+	}
+
+	sr.Status = "ok"
+	return sr
+}
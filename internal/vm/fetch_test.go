@@ -0,0 +1,107 @@
+package vm
+
+import (
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestParseRemoteSpec(t *testing.T) {
+	tests := []struct {
+		spec    string
+		target  string
+		remote  string
+		wantErr bool
+	}{
+		{spec: "prod:/etc/nginx/nginx.conf", target: "prod", remote: "/etc/nginx/nginx.conf"},
+		{spec: "web:!web-3:/var/log/*.log", target: "web:!web-3", remote: "/var/log/*.log"},
+		{spec: "env=prod:/etc/hosts", target: "env=prod", remote: "/etc/hosts"},
+		{spec: "prod:etc/hosts", wantErr: true},
+		{spec: ":/etc/hosts", wantErr: true},
+		{spec: "/etc/hosts", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			target, remote, err := ParseRemoteSpec(tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %q %q", target, remote)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if target != tt.target || remote != tt.remote {
+				t.Errorf("ParseRemoteSpec() = %q, %q, want %q, %q", target, remote, tt.target, tt.remote)
+			}
+		})
+	}
+}
+
+func TestFetchDestination(t *testing.T) {
+	if got := fetchDestination("configs", "prod", false); got != "configs" {
+		t.Errorf("single host destination = %s", got)
+	}
+	if got := fetchDestination("configs", "prod", true); got != filepath.Join("configs", "prod") {
+		t.Errorf("per-host destination = %s", got)
+	}
+	for alias, want := range map[string]string{"web/1": "web_1", "..": "___", "../etc": ".._etc", "db 2": "db_2"} {
+		if got := fetchDestination("configs", alias, true); got != filepath.Join("configs", want) {
+			t.Errorf("fetchDestination(%q) = %s, want %s", alias, got, filepath.Join("configs", want))
+		}
+	}
+}
+
+func TestFetchRelativePaths(t *testing.T) {
+	tests := []struct {
+		pattern, remote, want string
+	}{
+		{"/etc/nginx/nginx.conf", "/etc/nginx/nginx.conf", "nginx.conf"},
+		{"/var/log/*.log", "/var/log/syslog.log", "syslog.log"},
+		{"/srv/*/config.yml", "/srv/api/config.yml", "api/config.yml"},
+		{"/srv/*/config.yml", "/srv/web/config.yml", "web/config.yml"},
+		{"/*.conf", "/app.conf", "app.conf"},
+	}
+	for _, tt := range tests {
+		got, err := remoteRel(globBase(tt.pattern), tt.remote)
+		if err != nil || got != tt.want {
+			t.Errorf("%s matching %s: got %q, %v, want %q", tt.pattern, tt.remote, got, err, tt.want)
+		}
+	}
+
+	if _, err := remoteRel("/srv/app", "/srv/application/x"); err == nil {
+		t.Error("expected error for a path outside the base")
+	}
+
+	if got, err := localRel("/srv", "/srv/api/config.yml"); err != nil || got != filepath.Join("api", "config.yml") {
+		t.Errorf("localRel() = %q, %v", got, err)
+	}
+	if runtime.GOOS == "windows" {
+		if _, err := localRel("/srv", `/srv/..\..\x`); err == nil {
+			t.Error("expected error for a remote name that escapes the local directory")
+		}
+	}
+}
+
+func TestFetchStepValidation(t *testing.T) {
+	cfg := &ClientConfig{
+		Hosts: map[string]ServerConfig{"prod": {Host: "h", User: "u", Key: "k"}},
+		Tasks: map[string][]TaskStep{"t": {{Type: "fetch"}}},
+	}
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected validation error")
+	}
+	for _, want := range []string{"tasks.t[0].remote: missing remote path", "tasks.t[0].local: missing local directory"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+
+	if got := stepLabel(TaskStep{Type: "fetch", Remote: "/etc/hosts"}); got != "fetch:/etc/hosts" {
+		t.Errorf("stepLabel() = %s", got)
+	}
+}
@@ -0,0 +1,295 @@
+package vm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/goccy/go-yaml/ast"
+	"github.com/goccy/go-yaml/parser"
+	"github.com/pelletier/go-toml/v2"
+	"github.com/pelletier/go-toml/v2/unstable"
+)
+
+func configFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return "YAML"
+	case ".toml":
+		return "TOML"
+	default:
+		return "JSON"
+	}
+}
+
+func decodeConfig(path string, data []byte, v any) error {
+	switch configFormat(path) {
+	case "YAML":
+		return decodeYAML(data, v)
+	case "TOML":
+		return decodeTOML(data, v)
+	default:
+		if err := checkJSON(data); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, v); err != nil {
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) {
+				typeErr.Field = fieldPath(reflect.TypeOf(v), typeErr.Field)
+			}
+			return jsonSyntaxError(data, err)
+		}
+		return nil
+	}
+}
+
+func decodeYAML(data []byte, v any) error {
+	file, err := parser.ParseBytes(data, 0)
+	if err != nil {
+		return yamlSyntaxError(err)
+	}
+
+	var generic any
+	if err := yaml.Unmarshal(data, &generic); err != nil {
+		return yamlSyntaxError(err)
+	}
+
+	err = decodeGeneric(generic, v)
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		msg := fmt.Sprintf("%s: cannot use %s as %s", fieldPath(reflect.TypeOf(v), typeErr.Field), typeErr.Value, typeErr.Type)
+		if node := findYAMLNode(file, strings.Split(typeErr.Field, ".")); node != nil {
+			pos := node.GetToken().Position
+			return &SyntaxError{Format: "YAML", Line: pos.Line, Column: pos.Column, Msg: msg}
+		}
+		return &SyntaxError{Format: "YAML", Msg: msg}
+	}
+	return err
+}
+
+func decodeTOML(data []byte, v any) error {
+	var generic map[string]any
+	if err := toml.Unmarshal(data, &generic); err != nil {
+		var decodeErr *toml.DecodeError
+		if errors.As(err, &decodeErr) {
+			line, col := decodeErr.Position()
+			return &SyntaxError{Format: "TOML", Line: line, Column: col, Msg: decodeErr.Error()}
+		}
+		return &SyntaxError{Format: "TOML", Msg: err.Error()}
+	}
+
+	err := decodeGeneric(generic, v)
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		msg := fmt.Sprintf("%s: cannot use %s as %s", fieldPath(reflect.TypeOf(v), typeErr.Field), typeErr.Value, typeErr.Type)
+		if pos, ok := findTOMLPosition(data, strings.Split(typeErr.Field, ".")); ok {
+			return &SyntaxError{Format: "TOML", Line: pos.Line, Column: pos.Column, Msg: msg}
+		}
+		return &SyntaxError{Format: "TOML", Msg: msg}
+	}
+	return err
+}
+
+func decodeGeneric(generic, v any) error {
+	data, err := json.Marshal(stringKeys(generic))
+	if err != nil {
+		return fmt.Errorf("converting config: %w", err)
+	}
+	return json.Unmarshal(data, v)
+}
+
+// fieldPath turns the dotted field of a JSON type error into the path
+// notation Validate uses, with list indices in brackets
+// (tasks.deploy.2.remote becomes tasks.deploy[2].remote). t is the type the
+// document was decoded into; it tells list indices apart from map keys.
+func fieldPath(t reflect.Type, field string) string {
+	if field == "" {
+		return ""
+	}
+
+	var p string
+	for _, seg := range strings.Split(field, ".") {
+		for t != nil && t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		if _, err := strconv.Atoi(seg); err != nil && t == reflectTaskSteps {
+			t = reflectTask
+		}
+
+		var kind reflect.Kind
+		if t != nil {
+			kind = t.Kind()
+		}
+		switch kind {
+		case reflect.Slice, reflect.Array:
+			p += "[" + seg + "]"
+			t = t.Elem()
+			continue
+		case reflect.Struct:
+			if f, ok := jsonField(t, seg); ok {
+				t = f.Type
+			} else {
+				t = nil
+			}
+		case reflect.Map:
+			t = t.Elem()
+		default:
+			t = nil
+		}
+		p = joinConfigPath(p, seg)
+	}
+	return p
+}
+
+func stringKeys(v any) any {
+	switch val := v.(type) {
+	case map[string]any:
+		for k, item := range val {
+			val[k] = stringKeys(item)
+		}
+		return val
+	case map[any]any:
+		out := make(map[string]any, len(val))
+		for k, item := range val {
+			out[fmt.Sprint(k)] = stringKeys(item)
+		}
+		return out
+	case []any:
+		for i, item := range val {
+			val[i] = stringKeys(item)
+		}
+		return val
+	default:
+		return v
+	}
+}
+
+func findYAMLNode(file *ast.File, segments []string) ast.Node {
+	if len(file.Docs) == 0 {
+		return nil
+	}
+
+	node := file.Docs[0].Body
+	for _, seg := range segments {
+		node = yamlChild(node, seg)
+		if node == nil {
+			return nil
+		}
+	}
+	return node
+}
+
+func yamlChild(node ast.Node, key string) ast.Node {
+	switch n := node.(type) {
+	case *ast.AnchorNode:
+		return yamlChild(n.Value, key)
+	case *ast.TagNode:
+		return yamlChild(n.Value, key)
+	case *ast.MappingNode:
+		for _, mv := range n.Values {
+			if child := yamlChild(mv, key); child != nil {
+				return child
+			}
+		}
+	case *ast.MappingValueNode:
+		if n.Key != nil && n.Key.GetToken() != nil && n.Key.GetToken().Value == key {
+			return n.Value
+		}
+	case *ast.SequenceNode:
+		if i, err := strconv.Atoi(key); err == nil && i >= 0 && i < len(n.Values) {
+			return n.Values[i]
+		}
+	}
+	return nil
+}
+
+// tomlFinder looks for the deepest TOML key or value on a decoded field
+// path. Array tables and array elements count as numbered path segments.
+type tomlFinder struct {
+	p        *unstable.Parser
+	segments []string
+	depth    int
+	pos      unstable.Position
+}
+
+func findTOMLPosition(data []byte, segments []string) (unstable.Position, bool) {
+	f := &tomlFinder{p: &unstable.Parser{}, segments: segments}
+	f.p.Reset(data)
+
+	var table []string
+	arrays := map[string]int{}
+	for f.p.NextExpression() {
+		expr := f.p.Expression()
+		switch expr.Kind {
+		case unstable.Table, unstable.ArrayTable:
+			var last *unstable.Node
+			table = nil
+			for it := expr.Key(); it.Next(); {
+				last = it.Node()
+				table = append(table, string(last.Data))
+			}
+			if expr.Kind == unstable.ArrayTable {
+				name := strings.Join(table, ".")
+				table = append(table, strconv.Itoa(arrays[name]))
+				arrays[name]++
+			}
+			f.mark(table, last)
+		case unstable.KeyValue:
+			f.keyValue(table, expr)
+		}
+	}
+	return f.pos, f.depth > 0
+}
+
+func (f *tomlFinder) keyValue(prefix []string, kv *unstable.Node) {
+	path := prefix[:len(prefix):len(prefix)]
+	var last *unstable.Node
+	for it := kv.Key(); it.Next(); {
+		last = it.Node()
+		path = append(path, string(last.Data))
+	}
+	f.value(path, last, kv.Value())
+}
+
+func (f *tomlFinder) value(path []string, at, v *unstable.Node) {
+	if v.Raw.Length > 0 {
+		at = v
+	}
+	if !f.mark(path, at) {
+		return
+	}
+	switch v.Kind {
+	case unstable.InlineTable:
+		for it := v.Children(); it.Next(); {
+			f.keyValue(path, it.Node())
+		}
+	case unstable.Array:
+		i := 0
+		for it := v.Children(); it.Next(); i++ {
+			f.value(append(path[:len(path):len(path)], strconv.Itoa(i)), at, it.Node())
+		}
+	}
+}
+
+// mark records the position of node if path leads towards the wanted field
+// and reports whether it does.
+func (f *tomlFinder) mark(path []string, node *unstable.Node) bool {
+	if len(path) > len(f.segments) {
+		return false
+	}
+	for i, seg := range path {
+		if f.segments[i] != seg {
+			return false
+		}
+	}
+	if len(path) > f.depth && node != nil {
+		f.depth = len(path)
+		f.pos = f.p.Shape(node.Raw).Start
+	}
+	return true
+}
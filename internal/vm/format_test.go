@@ -0,0 +1,210 @@
+package vm
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadClientConfig_Formats(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"acme.json": `{
+			"hosts": {"prod": {"host": "10.0.0.1", "user": "admin", "key": "~/.ssh/id_rsa"}},
+			"tasks": {"deploy-config": [
+				{"type": "file", "local": "./nginx.conf", "remote": "/etc/nginx/nginx.conf"},
+				{"type": "exec", "run": "systemctl reload nginx"}
+			]}
+		}`,
+		"acme.yaml": `
+# production web server
+hosts:
+  prod:
+    host: 10.0.0.1
+    user: admin
+    key: ~/.ssh/id_rsa
+tasks:
+  deploy-config:
+    - type: file
+      local: ./nginx.conf
+      remote: /etc/nginx/nginx.conf
+    # reload only after the config is in place
+    - type: exec
+      run: systemctl reload nginx
+`,
+		"acme.yml": `
+hosts: {prod: {host: 10.0.0.1, user: admin, key: ~/.ssh/id_rsa}}
+tasks:
+  deploy-config:
+    - {type: file, local: ./nginx.conf, remote: /etc/nginx/nginx.conf}
+    - {type: exec, run: systemctl reload nginx}
+`,
+		"acme.toml": `
+# production web server
+[hosts.prod]
+host = "10.0.0.1"
+user = "admin"
+key = "~/.ssh/id_rsa"
+
+[[tasks.deploy-config]]
+type = "file"
+local = "./nginx.conf"
+remote = "/etc/nginx/nginx.conf"
+
+# reload only after the config is in place
+[[tasks.deploy-config]]
+type = "exec"
+run = "systemctl reload nginx"
+`,
+	}
+
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name)
+			os.WriteFile(path, []byte(content), 0644)
+
+			cfg, err := LoadClientConfig(path)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cfg.Hosts["prod"].Host != "10.0.0.1" {
+				t.Errorf("got host %q, want %q", cfg.Hosts["prod"].Host, "10.0.0.1")
+			}
+			steps := cfg.Tasks["deploy-config"]
+			if len(steps) != 2 {
+				t.Fatalf("got %d steps, want 2", len(steps))
+			}
+			if steps[0].Remote != "/etc/nginx/nginx.conf" || steps[1].Run != "systemctl reload nginx" {
+				t.Errorf("got steps %+v", steps)
+			}
+		})
+	}
+}
+
+func TestLoadClientConfig_ErrorPositions(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name     string
+		file     string
+		content  string
+		wantLine int
+		wantCol  int
+		wantPath string
+	}{
+		{
+			name:     "JSON syntax",
+			file:     "bad.json",
+			content:  "{\n  \"hosts\": {\n    \"prod\": {\"host\": \"h\",}\n  }\n}",
+			wantLine: 3,
+			wantCol:  26,
+		},
+		{
+			name:     "JSON type",
+			file:     "type.json",
+			content:  "{\n  \"hosts\": {\n    \"prod\": {\"host\": 42}\n  }\n}",
+			wantLine: 3,
+			wantCol:  23,
+		},
+		{
+			name:     "JSON type in task",
+			file:     "task.json",
+			content:  "{\n  \"tasks\": {\n    \"d\": [{\"type\": \"exec\", \"sudo\": \"yes\"}]\n  }\n}",
+			wantLine: 3,
+			wantCol:  40,
+			wantPath: "tasks.d[0].sudo:",
+		},
+		{
+			name:     "YAML syntax",
+			file:     "bad.yaml",
+			content:  "hosts:\n  prod:\n    host: h\n   user: u\n",
+			wantLine: 4,
+			wantCol:  4,
+		},
+		{
+			name:     "YAML type",
+			file:     "type.yaml",
+			content:  "hosts:\n  prod:\n    host: h\n    user: [a, b]\n",
+			wantLine: 4,
+			wantCol:  11,
+		},
+		{
+			name:     "YAML type in task",
+			file:     "task.yaml",
+			content:  "tasks:\n  d:\n    steps:\n      - type: exec\n        sudo: [a]\n",
+			wantLine: 5,
+			wantCol:  15,
+			wantPath: "tasks.d.steps[0].sudo:",
+		},
+		{
+			name:     "TOML syntax",
+			file:     "bad.toml",
+			content:  "[hosts.prod]\nhost = \"h\"\nuser = \n",
+			wantLine: 3,
+			wantCol:  8,
+		},
+		{
+			name:     "TOML type",
+			file:     "type.toml",
+			content:  "[hosts.prod]\nhost = \"h\"\nuser = 42\n",
+			wantLine: 3,
+			wantCol:  8,
+		},
+		{
+			name:     "TOML type in array table",
+			file:     "task.toml",
+			content:  "[[tasks.d]]\ntype = \"exec\"\n\n[[tasks.d]]\ntype = \"exec\"\nsudo = \"yes\"\n",
+			wantLine: 6,
+			wantCol:  8,
+			wantPath: "tasks.d[1].sudo:",
+		},
+		{
+			name:     "TOML type in inline table",
+			file:     "inline.toml",
+			content:  "[tasks.d]\nsteps = [\n  {type = \"exec\"},\n  {type = \"exec\", sudo = true, args = \"x\"},\n]\n",
+			wantLine: 4,
+			wantCol:  39,
+			wantPath: "tasks.d.steps[1].args:",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.file)
+			os.WriteFile(path, []byte(tt.content), 0644)
+
+			_, err := LoadClientConfig(path)
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("got error %v, want *SyntaxError", err)
+			}
+			if syntaxErr.Line != tt.wantLine || syntaxErr.Column != tt.wantCol {
+				t.Errorf("got position %d:%d, want %d:%d (%v)", syntaxErr.Line, syntaxErr.Column, tt.wantLine, tt.wantCol, err)
+			}
+			if !strings.Contains(err.Error(), tt.wantPath) {
+				t.Errorf("error %q does not mention %s", err, tt.wantPath)
+			}
+		})
+	}
+}
+
+func TestLoadManifest_YAML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "servers.yaml")
+	os.WriteFile(path, []byte(`
+servers:
+  - {host: 10.0.0.1, user: admin, password: secret}
+files:
+  - {local: ./app.conf, remote: /etc/app.conf, restart: systemctl reload app}
+`), 0644)
+
+	m, err := LoadManifest(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m.Files[0].Restart != "systemctl reload app" {
+		t.Errorf("got restart %q, want %q", m.Files[0].Restart, "systemctl reload app")
+	}
+}
@@ -0,0 +1,201 @@
+package vm
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+func loadConfigTree(path string, stack []string) (*ClientConfig, error) {
+	path = filepath.Clean(path)
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("resolving config path %s: %w", path, err)
+	}
+	for i, p := range stack {
+		if p == abs {
+			cycle := append(append([]string{}, stack[i:]...), abs)
+			return nil, fmt.Errorf("config: include cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+
+	data, err := readConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg ClientConfig
+	if err := decodeConfig(path, data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	cfg.Backup.Dir = resolveConfigPath(path, cfg.Backup.Dir)
+	cfg.Backup.KeyFile = resolveConfigPath(path, cfg.Backup.KeyFile)
+
+	unknown := unknownFields(decodeRaw(path, data), reflectClientConfig, "")
+	for i := range unknown {
+		unknown[i].File = path
+	}
+
+	merged := ClientConfig{
+		Hosts:    map[string]ServerConfig{},
+		Tasks:    map[string][]TaskStep{},
+		Groups:   map[string][]string{},
+		origins:  map[string]string{},
+		shadowed: map[string]string{},
+	}
+
+	for _, pattern := range cfg.Include {
+		files, err := expandInclude(path, pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			included, err := loadConfigTree(file, append(stack, abs))
+			if err != nil {
+				return nil, err
+			}
+			if err := merged.mergeIncluded(included); err != nil {
+				return nil, err
+			}
+			unknown = append(unknown, included.unknown...)
+			for key, origin := range included.shadowed {
+				merged.shadowed[key] = origin
+			}
+		}
+	}
+
+	for name, host := range cfg.Hosts {
+		merged.Hosts[name] = host
+		merged.origins[originKey("host", name)] = path
+	}
+	for name, members := range cfg.Groups {
+		merged.Groups[name] = members
+		merged.origins[originKey("group", name)] = path
+	}
+	for name := range cfg.Tasks {
+		if origin, ok := merged.origins[originKey("task", name)]; ok {
+			merged.shadowed[name] = origin
+		}
+		task, _ := cfg.task(name)
+		merged.setTask(name, task)
+		merged.origins[originKey("task", name)] = path
+	}
+
+	if reflect.ValueOf(cfg.Backup).IsZero() {
+		cfg.Backup = merged.Backup
+	} else {
+		merged.origins[originKey("section", "backup")] = path
+	}
+	if reflect.ValueOf(cfg.Normalize).IsZero() {
+		cfg.Normalize = merged.Normalize
+	} else {
+		merged.origins[originKey("section", "normalize")] = path
+	}
+
+	cfg.Hosts = merged.Hosts
+	cfg.Tasks = merged.Tasks
+	cfg.TaskOptions = merged.TaskOptions
+	cfg.Groups = merged.Groups
+	cfg.origins = merged.origins
+	cfg.source = path
+	cfg.unknown = unknown
+	cfg.shadowed = merged.shadowed
+
+	return &cfg, nil
+}
+
+func expandInclude(configPath, pattern string) ([]string, error) {
+	p := ExpandHome(pattern)
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(filepath.Dir(configPath), p)
+	}
+
+	if !strings.ContainsAny(p, "*?[") {
+		return []string{p}, nil
+	}
+
+	matches, err := filepath.Glob(p)
+	if err != nil {
+		return nil, fmt.Errorf("config %s: invalid include pattern %q: %w", configPath, pattern, err)
+	}
+
+	self, _ := filepath.Abs(configPath)
+	var files []string
+	for _, m := range matches {
+		if abs, _ := filepath.Abs(m); abs == self {
+			continue
+		}
+		files = append(files, m)
+	}
+	return files, nil
+}
+
+func (c *ClientConfig) mergeIncluded(inc *ClientConfig) error {
+	for name, host := range inc.Hosts {
+		key := originKey("host", name)
+		if err := c.checkConflict(key, "host", name, inc.origins[key]); err != nil {
+			return err
+		}
+		c.Hosts[name] = host
+		c.origins[key] = inc.origins[key]
+	}
+	for name := range inc.Tasks {
+		key := originKey("task", name)
+		if err := c.checkConflict(key, "task", name, inc.origins[key]); err != nil {
+			return err
+		}
+		task, _ := inc.task(name)
+		c.setTask(name, task)
+		c.origins[key] = inc.origins[key]
+	}
+	for name, members := range inc.Groups {
+		key := originKey("group", name)
+		if err := c.checkConflict(key, "group", name, inc.origins[key]); err != nil {
+			return err
+		}
+		c.Groups[name] = members
+		c.origins[key] = inc.origins[key]
+	}
+	if !reflect.ValueOf(inc.Backup).IsZero() {
+		key := originKey("section", "backup")
+		if err := c.checkConflict(key, "section", "backup", inc.origins[key]); err != nil {
+			return err
+		}
+		c.Backup = inc.Backup
+		c.origins[key] = inc.origins[key]
+	}
+	if !reflect.ValueOf(inc.Normalize).IsZero() {
+		key := originKey("section", "normalize")
+		if err := c.checkConflict(key, "section", "normalize", inc.origins[key]); err != nil {
+			return err
+		}
+		c.Normalize = inc.Normalize
+		c.origins[key] = inc.origins[key]
+	}
+	return nil
+}
+
+func (c *ClientConfig) checkConflict(key, kind, name, origin string) error {
+	if existing, ok := c.origins[key]; ok && existing != origin {
+		return fmt.Errorf("config: %s %q defined in both %s and %s", kind, name, existing, origin)
+	}
+	return nil
+}
+
+func originKey(kind, name string) string {
+	return kind + ":" + name
+}
+
+func (c *ClientConfig) Origin(kind, name string) string {
+	return c.origins[originKey(kind, name)]
+}
+
+func (c *ClientConfig) fileOf(kind, name string) string {
+	origin := c.Origin(kind, name)
+	if origin == c.source {
+		return ""
+	}
+	return origin
+}
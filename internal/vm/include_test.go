@@ -0,0 +1,206 @@
+package vm
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfigFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLoadClientConfigIncludes(t *testing.T) {
+	t.Run("merges included hosts and tasks", func(t *testing.T) {
+		dir := t.TempDir()
+		writeConfigFiles(t, dir, map[string]string{
+			"main.json": `{
+				"include": ["shared/hosts.json", "tasks/*.yaml"],
+				"tasks": {"local": [{"type": "exec", "run": "uptime"}]}
+			}`,
+			"shared/hosts.json": `{"hosts": {"web": {"host": "10.0.0.1", "user": "admin", "key": "~/.ssh/id_rsa"}}}`,
+			"tasks/nginx.yaml":  "tasks:\n  reload-nginx:\n    - type: exec\n      run: systemctl reload nginx\n",
+			"tasks/php.yaml":    "tasks:\n  restart-php:\n    - type: exec\n      run: systemctl restart php-fpm\n",
+		})
+
+		cfg, err := LoadClientConfig(filepath.Join(dir, "main.json"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, ok := cfg.Hosts["web"]; !ok {
+			t.Error("included host web missing")
+		}
+		for _, name := range []string{"local", "reload-nginx", "restart-php"} {
+			if _, ok := cfg.Tasks[name]; !ok {
+				t.Errorf("task %s missing", name)
+			}
+		}
+		if got, want := cfg.Origin("task", "reload-nginx"), filepath.Join(dir, "tasks", "nginx.yaml"); got != want {
+			t.Errorf("origin = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("including file overrides included definitions", func(t *testing.T) {
+		dir := t.TempDir()
+		writeConfigFiles(t, dir, map[string]string{
+			"main.json": `{
+				"include": ["lib.json"],
+				"hosts": {"web": {"host": "10.0.0.9", "user": "admin", "password": "pass"}},
+				"tasks": {"check": [{"type": "exec", "run": "nginx -t"}]}
+			}`,
+			"lib.json": `{
+				"hosts": {"web": {"host": "10.0.0.1", "user": "admin", "password": "pass"}},
+				"tasks": {"check": [{"type": "exec", "run": "true"}]}
+			}`,
+		})
+
+		cfg, err := LoadClientConfig(filepath.Join(dir, "main.json"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if cfg.Hosts["web"].Host != "10.0.0.9" {
+			t.Errorf("host = %s, want override 10.0.0.9", cfg.Hosts["web"].Host)
+		}
+		if cfg.Tasks["check"][0].Run != "nginx -t" {
+			t.Errorf("task run = %s, want override", cfg.Tasks["check"][0].Run)
+		}
+	})
+
+	t.Run("same library included twice is not a conflict", func(t *testing.T) {
+		dir := t.TempDir()
+		writeConfigFiles(t, dir, map[string]string{
+			"main.json":   `{"include": ["a.json", "b.json"], "hosts": {"web": {"host": "h", "user": "u", "password": "p"}}}`,
+			"a.json":      `{"include": ["common.json"]}`,
+			"b.json":      `{"include": ["common.json"]}`,
+			"common.json": `{"tasks": {"uptime": [{"type": "exec", "run": "uptime"}]}}`,
+		})
+
+		if _, err := LoadClientConfig(filepath.Join(dir, "main.json")); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("merges included backup and normalize sections", func(t *testing.T) {
+		dir := t.TempDir()
+		writeConfigFiles(t, dir, map[string]string{
+			"main.json": `{
+				"include": ["shared/backup.json", "shared/normalize.yaml"],
+				"hosts": {"web": {"host": "h", "user": "u", "password": "p"}},
+				"tasks": {"uptime": [{"type": "exec", "run": "uptime"}]}
+			}`,
+			"shared/backup.json":    `{"backup": {"dir": "backups", "mode": "both"}}`,
+			"shared/normalize.yaml": "normalize:\n  fixes: [bom]\n",
+		})
+
+		cfg, err := LoadClientConfig(filepath.Join(dir, "main.json"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if want := filepath.Join(dir, "shared", "backups"); cfg.Backup.Dir != want || cfg.Backup.Mode != "both" {
+			t.Errorf("backup = %+v, want dir %s relative to the included file", cfg.Backup, want)
+		}
+		if len(cfg.Normalize.Fixes) != 1 || cfg.Normalize.Fixes[0] != "bom" {
+			t.Errorf("normalize = %+v", cfg.Normalize)
+		}
+
+		writeConfigFiles(t, dir, map[string]string{
+			"main.json": `{
+				"include": ["shared/backup.json"],
+				"hosts": {"web": {"host": "h", "user": "u", "password": "p"}},
+				"tasks": {"uptime": [{"type": "exec", "run": "uptime"}]},
+				"backup": {"mode": "remote"}
+			}`,
+		})
+		cfg, err = LoadClientConfig(filepath.Join(dir, "main.json"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if cfg.Backup.Mode != "remote" || cfg.Backup.Dir != "" {
+			t.Errorf("backup = %+v, want the including file's section", cfg.Backup)
+		}
+	})
+
+	tests := []struct {
+		name    string
+		files   map[string]string
+		wantErr []string
+	}{
+		{
+			name: "conflicting included tasks",
+			files: map[string]string{
+				"main.json": `{"include": ["a.json", "b.json"], "hosts": {"web": {"host": "h", "user": "u", "password": "p"}}}`,
+				"a.json":    `{"tasks": {"deploy": [{"type": "exec", "run": "a"}]}}`,
+				"b.json":    `{"tasks": {"deploy": [{"type": "exec", "run": "b"}]}}`,
+			},
+			wantErr: []string{`task "deploy" defined in both`, "a.json", "b.json"},
+		},
+		{
+			name: "conflicting included backup sections",
+			files: map[string]string{
+				"main.json": `{"include": ["a.json", "b.json"], "hosts": {"web": {"host": "h", "user": "u", "password": "p"}}}`,
+				"a.json":    `{"backup": {"mode": "local"}}`,
+				"b.json":    `{"backup": {"mode": "remote"}}`,
+			},
+			wantErr: []string{`section "backup" defined in both`, "a.json", "b.json"},
+		},
+		{
+			name: "invalid included normalize section names its file",
+			files: map[string]string{
+				"main.json": `{"include": ["lib.json"], "hosts": {"web": {"host": "h", "user": "u", "password": "p"}}, "tasks": {"t": [{"type": "exec", "run": "true"}]}}`,
+				"lib.json":  `{"normalize": {"tab_width": -1}}`,
+			},
+			wantErr: []string{"normalize.tab_width: tab_width must not be negative (from ", "lib.json)"},
+		},
+		{
+			name: "include cycle",
+			files: map[string]string{
+				"main.json": `{"include": ["a.json"]}`,
+				"a.json":    `{"include": ["b.json"]}`,
+				"b.json":    `{"include": ["a.json"]}`,
+			},
+			wantErr: []string{"include cycle", "a.json -> ", "b.json -> ", "a.json"},
+		},
+		{
+			name: "missing include",
+			files: map[string]string{
+				"main.json": `{"include": ["missing.json"]}`,
+			},
+			wantErr: []string{"reading config", "missing.json"},
+		},
+		{
+			name: "invalid included task names its file",
+			files: map[string]string{
+				"main.json": `{"include": ["lib.json"], "hosts": {"web": {"host": "h", "user": "u", "password": "p"}}}`,
+				"lib.json":  `{"tasks": {"broken": [{"type": "exec"}]}}`,
+			},
+			wantErr: []string{"tasks.broken[0].run: missing run command (from ", "lib.json)"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeConfigFiles(t, dir, tt.files)
+
+			_, err := LoadClientConfig(filepath.Join(dir, "main.json"))
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not contain %q", err, want)
+				}
+			}
+		})
+	}
+}
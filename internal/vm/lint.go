@@ -0,0 +1,307 @@
+package vm
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+type LintWarning struct {
+	Rule    string `json:"rule"`
+	Path    string `json:"path"`
+	Message string `json:"message"`
+	File    string `json:"file,omitempty"`
+
+	task string
+	host string
+}
+
+func (w LintWarning) String() string {
+	msg := fmt.Sprintf("%s: %s [%s]", w.Path, w.Message, w.Rule)
+	if w.File != "" {
+		msg += " (from " + w.File + ")"
+	}
+	return msg
+}
+
+type LintRule struct {
+	ID          string
+	Description string
+	check       func(c *ClientConfig) []LintWarning
+}
+
+var LintRules = []LintRule{
+	{"file-no-reload", "file step not followed by an exec step that reloads or restarts the service", lintFileNoReload},
+	{"inline-password", "password or passphrase stored in the config file instead of a secret reference", lintInlinePassword},
+	{"missing-local", "relative local path that does not exist", lintMissingLocal},
+	{"relative-remote", "remote path that is not absolute", lintRelativeRemote},
+	{"duplicate-host", "several host aliases pointing at the same address", lintDuplicateHost},
+	{"shadowed-task", "included task that is overridden and can never run", lintShadowedTask},
+	{"unused-task", "task that no other task calls and that has neither params nor default hosts", lintUnusedTask},
+	{"dangerous-rm", "exec command that recursively removes /", lintDangerousRm},
+}
+
+var reloadCommand = regexp.MustCompile(`\b(?:reload|restart)\b`)
+
+var dangerousRm = regexp.MustCompile(`(?:^|[\s;&|(])rm\s+((?:-\S+\s+)+)(?:/\*?|"/\*?"|'/\*?')(?:$|[\s;&|)])`)
+
+func (c *ClientConfig) Lint() []LintWarning {
+	var warnings []LintWarning
+	for _, rule := range LintRules {
+		for _, w := range rule.check(c) {
+			if w.task != "" && lintIgnored(c.TaskOptions[w.task].LintIgnore, rule.ID) {
+				continue
+			}
+			if w.host != "" && lintIgnored(c.Hosts[w.host].LintIgnore, rule.ID) {
+				continue
+			}
+			w.Rule = rule.ID
+			warnings = append(warnings, w)
+		}
+	}
+
+	sort.SliceStable(warnings, func(i, j int) bool {
+		if warnings[i].Path != warnings[j].Path {
+			return lessConfigPath(warnings[i].Path, warnings[j].Path)
+		}
+		return warnings[i].Rule < warnings[j].Rule
+	})
+	return warnings
+}
+
+func ValidateLintRules(ids []string) error {
+	for _, id := range ids {
+		known := false
+		for _, rule := range LintRules {
+			if rule.ID == id {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown lint rule %q", id)
+		}
+	}
+	return nil
+}
+
+func lintIgnored(ignore []string, rule string) bool {
+	for _, id := range ignore {
+		if id == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// taskSteps is one list of steps of a task: its main steps or its
+// on_failure handlers, with the config path of the list.
+type taskSteps struct {
+	path  string
+	steps []TaskStep
+}
+
+func (c *ClientConfig) lintSteps(name string) []taskSteps {
+	lists := []taskSteps{{c.taskStepsPath(name), c.Tasks[name]}}
+	if onFailure := c.TaskOptions[name].OnFailure; len(onFailure) > 0 {
+		lists = append(lists, taskSteps{"tasks." + name + ".on_failure", onFailure})
+	}
+	return lists
+}
+
+func (c *ClientConfig) taskWarning(task, list string, step int, field, format string, args ...any) LintWarning {
+	p := "tasks." + task
+	if step >= 0 {
+		p = fmt.Sprintf("%s[%d]", list, step)
+	}
+	if field != "" {
+		p += "." + field
+	}
+	return LintWarning{Path: p, Message: fmt.Sprintf(format, args...), File: c.fileOf("task", task), task: task}
+}
+
+func lintFileNoReload(c *ClientConfig) []LintWarning {
+	var warnings []LintWarning
+	for _, name := range sortedKeys(c.Tasks) {
+		for _, list := range c.lintSteps(name) {
+			steps := list.steps
+			last := -1
+			for i, step := range steps {
+				if step.Type == "file" {
+					last = i
+				}
+			}
+			if last < 0 {
+				continue
+			}
+			if !c.reloads(steps[last+1:], []string{name}) {
+				warnings = append(warnings, c.taskWarning(name, list.path, last, "", "file %s is uploaded but nothing reloads or restarts the service afterwards", steps[last].Remote))
+			}
+		}
+	}
+	return warnings
+}
+
+// reloads reports whether any of steps, or a task they call, reboots or runs
+// a reload/restart command.
+func (c *ClientConfig) reloads(steps []TaskStep, stack []string) bool {
+	for _, step := range steps {
+		switch step.Type {
+		case "exec":
+			if reloadCommand.MatchString(step.Run) {
+				return true
+			}
+		case "reboot":
+			return true
+		case "task":
+			if slices.Contains(stack, step.Task) {
+				continue
+			}
+			if c.reloads(c.Tasks[step.Task], append(stack, step.Task)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func lintInlinePassword(c *ClientConfig) []LintWarning {
+	var warnings []LintWarning
+	for _, name := range sortedKeys(c.Hosts) {
+		host := c.Hosts[name]
+		fields := []struct{ key, value string }{
+			{"password", host.Password},
+			{"passphrase", host.Passphrase},
+			{"sudo_password", host.SudoPassword},
+		}
+		for _, f := range fields {
+			if f.value == "" || isSecretRef(f.value) {
+				continue
+			}
+			warnings = append(warnings, LintWarning{
+				Path:    "hosts." + name + "." + f.key,
+				Message: "stored in plain text in the config (use env:, file: or cmd:)",
+				File:    c.fileOf("host", name),
+				host:    name,
+			})
+		}
+	}
+	return warnings
+}
+
+func lintMissingLocal(c *ClientConfig) []LintWarning {
+	var warnings []LintWarning
+	for _, name := range sortedKeys(c.Tasks) {
+		for _, list := range c.lintSteps(name) {
+			for i, step := range list.steps {
+				if (step.Type != "file" && step.Type != "script") || step.Local == "" || filepath.IsAbs(ExpandHome(step.Local)) {
+					continue
+				}
+				if _, err := os.Stat(step.Local); err != nil {
+					warnings = append(warnings, c.taskWarning(name, list.path, i, "local", "%s does not exist (relative to the current directory)", step.Local))
+				}
+			}
+		}
+	}
+	return warnings
+}
+
+func lintRelativeRemote(c *ClientConfig) []LintWarning {
+	var warnings []LintWarning
+	for _, name := range sortedKeys(c.Tasks) {
+		for _, list := range c.lintSteps(name) {
+			for i, step := range list.steps {
+				if (step.Type == "file" || step.Type == "fetch") && step.Remote != "" && !path.IsAbs(step.Remote) {
+					warnings = append(warnings, c.taskWarning(name, list.path, i, "remote", "%s is not an absolute path", step.Remote))
+				}
+			}
+		}
+	}
+	return warnings
+}
+
+func lintDuplicateHost(c *ClientConfig) []LintWarning {
+	var warnings []LintWarning
+	first := map[string]string{}
+	for _, name := range sortedKeys(c.Hosts) {
+		addr := strings.ToLower(c.Hosts[name].Host)
+		if addr == "" {
+			continue
+		}
+		if other, ok := first[addr]; ok {
+			warnings = append(warnings, LintWarning{
+				Path:    "hosts." + name,
+				Message: fmt.Sprintf("points at %s, same as %s", c.Hosts[name].Host, other),
+				File:    c.fileOf("host", name),
+				host:    name,
+			})
+			continue
+		}
+		first[addr] = name
+	}
+	return warnings
+}
+
+func lintShadowedTask(c *ClientConfig) []LintWarning {
+	var warnings []LintWarning
+	for _, name := range sortedKeys(c.shadowed) {
+		warnings = append(warnings, c.taskWarning(name, "", -1, "", "definition from %s is overridden and never used", c.shadowed[name]))
+	}
+	return warnings
+}
+
+// lintUnusedTask flags tasks that nothing refers to: no other task calls
+// them, and they have no default hosts and no params that would mark them
+// as meant to be run or called.
+func lintUnusedTask(c *ClientConfig) []LintWarning {
+	called := map[string]bool{}
+	for _, name := range sortedKeys(c.Tasks) {
+		for _, list := range c.lintSteps(name) {
+			for _, step := range list.steps {
+				if step.Type == "task" && step.Task != name {
+					called[step.Task] = true
+				}
+			}
+		}
+	}
+
+	var warnings []LintWarning
+	for _, name := range sortedKeys(c.Tasks) {
+		opts := c.TaskOptions[name]
+		if len(opts.Params) > 0 || len(opts.Hosts) > 0 || called[name] {
+			continue
+		}
+		warnings = append(warnings, c.taskWarning(name, "", -1, "", "no task calls it and it has neither params nor default hosts"))
+	}
+	return warnings
+}
+
+func lintDangerousRm(c *ClientConfig) []LintWarning {
+	var warnings []LintWarning
+	for _, name := range sortedKeys(c.Tasks) {
+		for _, list := range c.lintSteps(name) {
+			for i, step := range list.steps {
+				if step.Type == "exec" && isDangerousRm(step.Run) {
+					warnings = append(warnings, c.taskWarning(name, list.path, i, "run", "command recursively removes /"))
+				}
+			}
+		}
+	}
+	return warnings
+}
+
+func isDangerousRm(cmd string) bool {
+	for _, m := range dangerousRm.FindAllStringSubmatch(cmd, -1) {
+		for _, flag := range strings.Fields(m[1]) {
+			if flag == "--recursive" || (!strings.HasPrefix(flag, "--") && strings.ContainsAny(flag, "rR")) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,261 @@
+package vm
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func lintSummary(warnings []LintWarning) string {
+	var out []string
+	for _, w := range warnings {
+		out = append(out, w.Rule+"@"+w.Path)
+	}
+	return strings.Join(out, " ")
+}
+
+func TestLint(t *testing.T) {
+	local := filepath.Join(t.TempDir(), "nginx.conf")
+
+	tests := []struct {
+		name string
+		cfg  ClientConfig
+		want string
+	}{
+		{
+			name: "clean",
+			cfg: ClientConfig{
+				Hosts: map[string]ServerConfig{"web": {Host: "10.0.0.1", User: "u", Key: "k"}},
+				Tasks: map[string][]TaskStep{"deploy": {
+					{Type: "file", Local: local, Remote: "/etc/nginx/nginx.conf"},
+					{Type: "exec", Run: "systemctl reload nginx"},
+				}},
+				TaskOptions: map[string]TaskOptions{"deploy": {Hosts: []string{"web"}}},
+			},
+		},
+		{
+			name: "file without reload",
+			cfg: ClientConfig{
+				Tasks: map[string][]TaskStep{"deploy": {
+					{Type: "exec", Run: "nginx -t"},
+					{Type: "file", Local: local, Remote: "/etc/nginx/nginx.conf"},
+				}},
+			},
+			want: "unused-task@tasks.deploy file-no-reload@tasks.deploy[1]",
+		},
+		{
+			name: "file followed by unrelated exec",
+			cfg: ClientConfig{
+				Tasks: map[string][]TaskStep{"deploy": {
+					{Type: "file", Local: local, Remote: "/etc/nginx/nginx.conf"},
+					{Type: "exec", Run: "echo done"},
+				}},
+			},
+			want: "unused-task@tasks.deploy file-no-reload@tasks.deploy[0]",
+		},
+		{
+			name: "reload in called task",
+			cfg: ClientConfig{
+				Tasks: map[string][]TaskStep{
+					"deploy": {
+						{Type: "file", Local: local, Remote: "/etc/nginx/nginx.conf"},
+						{Type: "task", Task: "restart"},
+					},
+					"restart": {{Type: "exec", Run: "service nginx restart"}},
+				},
+				TaskOptions: map[string]TaskOptions{"deploy": {Hosts: []string{"web"}}},
+			},
+		},
+		{
+			name: "hosts",
+			cfg: ClientConfig{
+				Hosts: map[string]ServerConfig{
+					"web":  {Host: "10.0.0.1", User: "u", Password: "secret"},
+					"web2": {Host: "10.0.0.1", User: "deploy", Key: "k"},
+				},
+			},
+			want: "inline-password@hosts.web.password duplicate-host@hosts.web2",
+		},
+		{
+			name: "suppressed per host",
+			cfg: ClientConfig{
+				Hosts: map[string]ServerConfig{
+					"web":  {Host: "10.0.0.1", User: "u", Password: "secret", LintIgnore: []string{"inline-password"}},
+					"web2": {Host: "10.0.0.1", User: "deploy", Key: "k", LintIgnore: []string{"duplicate-host"}},
+				},
+			},
+		},
+		{
+			name: "paths",
+			cfg: ClientConfig{
+				Tasks: map[string][]TaskStep{"deploy": {
+					{Type: "file", Local: "./does-not-exist.conf", Remote: "etc/app.conf"},
+					{Type: "exec", Run: "systemctl restart app"},
+				}},
+			},
+			want: "unused-task@tasks.deploy missing-local@tasks.deploy[0].local relative-remote@tasks.deploy[0].remote",
+		},
+		{
+			name: "dangerous rm",
+			cfg: ClientConfig{
+				Tasks: map[string][]TaskStep{"wipe": {
+					{Type: "exec", Run: "rm -rf /tmp/cache"},
+					{Type: "exec", Run: "cd /srv && rm -rf /"},
+					{Type: "exec", Run: "sudo rm -r -f /*"},
+				}},
+			},
+			want: "unused-task@tasks.wipe dangerous-rm@tasks.wipe[1].run dangerous-rm@tasks.wipe[2].run",
+		},
+		{
+			name: "suppressed per task",
+			cfg: ClientConfig{
+				Tasks: map[string][]TaskStep{
+					"upload": {{Type: "file", Local: local, Remote: "/srv/static/logo.svg"}},
+					"deploy": {{Type: "file", Local: local, Remote: "/etc/app.conf"}},
+				},
+				TaskOptions: map[string]TaskOptions{
+					"upload": {LintIgnore: []string{"file-no-reload", "unused-task"}},
+				},
+			},
+			want: "unused-task@tasks.deploy file-no-reload@tasks.deploy[0]",
+		},
+		{
+			name: "overridden included task",
+			cfg: ClientConfig{
+				Tasks:    map[string][]TaskStep{"check": {{Type: "exec", Run: "true"}}},
+				shadowed: map[string]string{"check": "lib.json"},
+			},
+			want: "shadowed-task@tasks.check unused-task@tasks.check",
+		},
+		{
+			name: "on_failure steps",
+			cfg: ClientConfig{
+				Tasks: map[string][]TaskStep{"deploy": {{Type: "exec", Run: "systemctl restart app"}}},
+				TaskOptions: map[string]TaskOptions{"deploy": {OnFailure: []TaskStep{
+					{Type: "fetch", Remote: "var/log/app.log", Local: "./logs"},
+					{Type: "exec", Run: "rm -rf /"},
+				}}},
+			},
+			want: "unused-task@tasks.deploy relative-remote@tasks.deploy.on_failure[0].remote dangerous-rm@tasks.deploy.on_failure[1].run",
+		},
+		{
+			name: "unused task",
+			cfg: ClientConfig{
+				Tasks: map[string][]TaskStep{
+					"restart":     {{Type: "exec", Run: "systemctl restart {{service}}"}},
+					"reload":      {{Type: "exec", Run: "systemctl reload {{service}}"}},
+					"rotate":      {{Type: "exec", Run: "logrotate -f {{conf}}"}},
+					"full-deploy": {{Type: "task", Task: "restart"}},
+				},
+				TaskOptions: map[string]TaskOptions{
+					"restart": {Params: map[string]string{"service": "nginx"}},
+					"reload":  {Params: map[string]string{"service": "nginx"}},
+					"rotate":  {Params: map[string]string{"conf": "/etc/logrotate.conf"}, Hosts: []string{"web"}},
+				},
+			},
+			want: "unused-task@tasks.full-deploy",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lintSummary(tt.cfg.Lint()); got != tt.want {
+				t.Errorf("Lint() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsDangerousRm(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want bool
+	}{
+		{"rm -rf /", true},
+		{"rm -Rf /*", true},
+		{"rm --recursive --force /", true},
+		{"rm -rf '/'", true},
+		{"rm -f /", false},
+		{"rm -rf /var/www/old", false},
+		{"rm -rf ./", false},
+		{"echo rm -rf /var", false},
+	}
+
+	for _, tt := range tests {
+		if got := isDangerousRm(tt.cmd); got != tt.want {
+			t.Errorf("isDangerousRm(%q) = %v, want %v", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestTaskShorthand(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFiles(t, dir, map[string]string{
+		"c.json": `{
+			"hosts": {"web": {"host": "h", "user": "u", "key": "k"}},
+			"tasks": {
+				"short": [{"type": "exec", "run": "uptime"}],
+				"long": {"steps": [{"type": "exec", "run": "uptime", "typo": 1}], "lint_ignore": ["dangerous-rm"]}
+			}
+		}`,
+	})
+
+	res := ExecuteValidate(filepath.Join(dir, "c.json"))
+	if len(res.Errors) != 1 || res.Errors[0].Path != "tasks.long.steps[0].typo" {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+
+	writeConfigFiles(t, dir, map[string]string{
+		"c.json": `{
+			"hosts": {"web": {"host": "h", "user": "u", "key": "k"}},
+			"tasks": {
+				"short": [{"type": "exec", "run": "uptime"}],
+				"long": {"steps": [{"type": "exec", "run": "uptime"}], "lint_ignore": ["dangerous-rm"]}
+			}
+		}`,
+	})
+	cfg, err := LoadClientConfig(filepath.Join(dir, "c.json"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.Tasks["short"]) != 1 || len(cfg.Tasks["long"]) != 1 {
+		t.Errorf("steps = %+v", cfg.Tasks)
+	}
+	if _, ok := cfg.TaskOptions["short"]; ok || len(cfg.TaskOptions["long"].LintIgnore) != 1 {
+		t.Errorf("options = %+v", cfg.TaskOptions)
+	}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"short":[{`) || !strings.Contains(string(data), `"long":{"steps":[{`) {
+		t.Errorf("marshalled tasks = %s", data)
+	}
+
+	writeConfigFiles(t, dir, map[string]string{
+		"c.json": `{
+			"hosts": {"web": {"host": "h", "user": "u", "key": "k"}},
+			"tasks": {"long": {"steps": [{"type": "exec", "run": "uptime"}], "lint_ignore": ["no-such-rule"]}}
+		}`,
+	})
+	if _, err := LoadClientConfig(filepath.Join(dir, "c.json")); err == nil || !strings.Contains(err.Error(), "tasks.long.lint_ignore") {
+		t.Errorf("expected lint_ignore error, got %v", err)
+	}
+
+	writeConfigFiles(t, dir, map[string]string{
+		"c.json": `{
+			"hosts": {"web": {"host": "h", "user": "u", "key": "k"}},
+			"tasks": {
+				"short": [{"type": "exec"}],
+				"bare": {"steps": [{"type": "exec"}]}
+			}
+		}`,
+	})
+	_, err = LoadClientConfig(filepath.Join(dir, "c.json"))
+	for _, want := range []string{"tasks.short[0].run", "tasks.bare.steps[0].run"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error at %s, got %v", want, err)
+		}
+	}
+}
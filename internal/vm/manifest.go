@@ -1,7 +1,6 @@
 package vm
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -11,6 +10,7 @@ import (
 type Manifest struct {
 	Servers []ServerConfig `json:"servers"`
 	Files   []FileConfig   `json:"files"`
+	Backup  BackupConfig   `json:"backup,omitempty"`
 }
 
 type ServerConfig struct {
@@ -18,6 +18,14 @@ type ServerConfig struct {
 	User     string `json:"user"`
 	Key      string `json:"key,omitempty"`
 	Password string `json:"password,omitempty"`
+
+	Passphrase   string `json:"passphrase,omitempty"`
+	SudoPassword string `json:"sudo_password,omitempty"`
+
+	Labels     map[string]string `json:"labels,omitempty"`
+	Protected  bool              `json:"protected,omitempty"`
+	ScriptDir  string            `json:"script_dir,omitempty"`
+	LintIgnore []string          `json:"lint_ignore,omitempty"`
 }
 
 type FileConfig struct {
@@ -27,16 +35,19 @@ type FileConfig struct {
 }
 
 func LoadManifest(path string) (*Manifest, error) {
-	data, err := os.ReadFile(path)
+	data, err := readConfigFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("reading manifest %s: %w", path, err)
+		return nil, err
 	}
 
 	var manifest Manifest
-	if err := json.Unmarshal(data, &manifest); err != nil {
+	if err := decodeConfig(path, data, &manifest); err != nil {
 		return nil, fmt.Errorf("parsing manifest: %w", err)
 	}
 
+	manifest.Backup.Dir = resolveConfigPath(path, manifest.Backup.Dir)
+	manifest.Backup.KeyFile = resolveConfigPath(path, manifest.Backup.KeyFile)
+
 	if err := ValidateManifest(&manifest); err != nil {
 		return nil, err
 	}
@@ -73,6 +84,10 @@ func ValidateManifest(m *Manifest) error {
 		}
 	}
 
+	if err := m.Backup.Validate(); err != nil {
+		return fmt.Errorf("manifest: %w", err)
+	}
+
 	return nil
 }
 
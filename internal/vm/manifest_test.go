@@ -63,6 +63,32 @@ func TestLoadManifest(t *testing.T) {
 		}
 	})
 
+	t.Run("manifest with backup config", func(t *testing.T) {
+		path := filepath.Join(dir, "backup.json")
+		os.WriteFile(path, []byte(`{
+			"servers": [{"host": "10.0.0.1", "user": "admin", "key": "~/.ssh/id_rsa"}],
+			"files": [{"local": "./app.conf", "remote": "/etc/app.conf"}],
+			"backup": {"dir": "backups", "mode": "both"}
+		}`), 0644)
+
+		m, err := LoadManifest(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if m.Backup.Mode != "both" || m.Backup.Dir != filepath.Join(dir, "backups") {
+			t.Errorf("got backup %+v", m.Backup)
+		}
+
+		os.WriteFile(path, []byte(`{
+			"servers": [{"host": "10.0.0.1", "user": "admin", "key": "~/.ssh/id_rsa"}],
+			"files": [{"local": "./app.conf", "remote": "/etc/app.conf"}],
+			"backup": {"mode": "elsewhere"}
+		}`), 0644)
+		if _, err := LoadManifest(path); err == nil {
+			t.Fatal("expected error for invalid backup mode")
+		}
+	})
+
 	t.Run("missing file", func(t *testing.T) {
 		_, err := LoadManifest(filepath.Join(dir, "nope.json"))
 		if err == nil {
@@ -4,17 +4,284 @@ import (
 	"bytes"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
 )
 
+const (
+	binarySniffLen  = 8000
+	defaultTabWidth = 4
+)
+
+var NormalizeFixes = []string{"utf16", "bom", "lone-cr", "tabs", "final-newline"}
+
+var (
+	utf8BOM    = []byte("\xef\xbb\xbf")
+	utf16LEBOM = []byte("\xff\xfe")
+	utf16BEBOM = []byte("\xfe\xff")
+)
+
+var DefaultBinaryExtensions = []string{
+	".7z", ".bin", ".bz2", ".class", ".db", ".deb", ".dll", ".exe", ".gif", ".gz",
+	".ico", ".jar", ".jpeg", ".jpg", ".mp3", ".mp4", ".pdf", ".png", ".pyc", ".rpm",
+	".so", ".sqlite", ".tar", ".tgz", ".war", ".webp", ".woff", ".woff2", ".xz", ".zip", ".zst",
+}
+
+var binaryMagic = []struct {
+	name  string
+	magic []byte
+}{
+	{"ELF", []byte("\x7fELF")},
+	{"zip", []byte("PK\x03\x04")},
+	{"gzip", []byte("\x1f\x8b")},
+	{"bzip2", []byte("BZh")},
+	{"xz", []byte("\xfd7zXZ\x00")},
+	{"zstd", []byte("\x28\xb5\x2f\xfd")},
+	{"7z", []byte("7z\xbc\xaf\x27\x1c")},
+	{"PNG", []byte("\x89PNG\r\n\x1a\n")},
+	{"JPEG", []byte("\xff\xd8\xff")},
+	{"GIF", []byte("GIF8")},
+	{"PDF", []byte("%PDF-")},
+	{"Java class", []byte("\xca\xfe\xba\xbe")},
+}
+
+type NormalizeConfig struct {
+	BinaryExtensions []string `json:"binary_extensions,omitempty"`
+	Fixes            []string `json:"fixes,omitempty"`
+	TabWidth         int      `json:"tab_width,omitempty"`
+}
+
+type NormalizeOptions struct {
+	Mode             string
+	BinaryExtensions []string
+	Fixes            []string
+	TabWidth         int
+}
+
+type NormalizeReport struct {
+	Applied bool
+	Reason  string
+	Fixes   []string
+}
+
+func (r NormalizeReport) String() string {
+	if !r.Applied {
+		return "skipped: " + r.Reason
+	}
+	if len(r.Fixes) == 0 {
+		return "applied"
+	}
+	return "applied: " + strings.Join(r.Fixes, ", ")
+}
+
+func ValidateNormalizeMode(mode string) error {
+	switch mode {
+	case "", "auto", "always", "never":
+		return nil
+	default:
+		return fmt.Errorf("normalize mode %q must be auto, always or never", mode)
+	}
+}
+
+func ValidateNormalizeFixes(fixes []string) error {
+	for _, fix := range fixes {
+		known := false
+		for _, f := range NormalizeFixes {
+			if f == fix {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown normalize fix %q (want one of %s)", fix, strings.Join(NormalizeFixes, ", "))
+		}
+	}
+	return nil
+}
+
 func NormalizeLineEndings(data []byte) []byte {
 	return bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
 }
 
-func NormalizeFile(path string) ([]byte, error) {
+func DetectBinary(path string, data []byte, extensions []string) (bool, string) {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext != "" {
+		for _, list := range [][]string{DefaultBinaryExtensions, extensions} {
+			for _, e := range list {
+				if strings.EqualFold(e, ext) {
+					return true, ext + " extension"
+				}
+			}
+		}
+	}
+
+	for _, m := range binaryMagic {
+		if bytes.HasPrefix(data, m.magic) {
+			return true, m.name + " signature"
+		}
+	}
+
+	sniff := data
+	if len(sniff) > binarySniffLen {
+		sniff = sniff[:binarySniffLen]
+	}
+	if bytes.IndexByte(sniff, 0) >= 0 {
+		return true, "NUL byte"
+	}
+
+	return false, ""
+}
+
+func NormalizeContent(path string, data []byte, opts NormalizeOptions) ([]byte, NormalizeReport, error) {
+	if opts.Mode == "never" {
+		return data, NormalizeReport{Reason: "normalize=never"}, nil
+	}
+
+	enabled := map[string]bool{}
+	for _, fix := range opts.Fixes {
+		enabled[fix] = true
+	}
+
+	var applied []string
+	if enabled["utf16"] {
+		decoded, ok, err := TranscodeUTF16(data)
+		if err != nil {
+			return data, NormalizeReport{}, err
+		}
+		if ok {
+			data = decoded
+			applied = append(applied, "utf16")
+		}
+	}
+
+	if opts.Mode != "always" {
+		if binary, reason := DetectBinary(path, data, opts.BinaryExtensions); binary {
+			return data, NormalizeReport{Reason: "binary (" + reason + ")"}, nil
+		}
+	}
+
+	if enabled["bom"] && bytes.HasPrefix(data, utf8BOM) {
+		data = data[len(utf8BOM):]
+		applied = append(applied, "bom")
+	}
+
+	if bytes.Contains(data, []byte("\r\n")) {
+		data = NormalizeLineEndings(data)
+		applied = append(applied, "crlf")
+	}
+
+	if enabled["lone-cr"] && bytes.IndexByte(data, '\r') >= 0 {
+		data = bytes.ReplaceAll(data, []byte("\r"), []byte("\n"))
+		applied = append(applied, "lone-cr")
+	}
+
+	if enabled["tabs"] && !needsTabs(path) {
+		width := opts.TabWidth
+		if width <= 0 {
+			width = defaultTabWidth
+		}
+		if expanded, ok := ExpandIndentTabs(data, width); ok {
+			data = expanded
+			applied = append(applied, "tabs")
+		}
+	}
+
+	if enabled["final-newline"] && len(data) > 0 && data[len(data)-1] != '\n' {
+		data = append(data[:len(data):len(data)], '\n')
+		applied = append(applied, "final-newline")
+	}
+
+	return data, NormalizeReport{Applied: true, Fixes: applied}, nil
+}
+
+// needsTabs reports whether tabs are part of the syntax of the file, as in
+// Makefile recipes, so indentation must be left alone.
+func needsTabs(path string) bool {
+	base := strings.ToLower(filepath.Base(path))
+	return base == "makefile" || base == "gnumakefile" || filepath.Ext(base) == ".mk"
+}
+
+// ExpandIndentTabs replaces tabs in the leading whitespace of every line with
+// spaces up to the next multiple of width. Tabs after the first other
+// character are kept.
+func ExpandIndentTabs(data []byte, width int) ([]byte, bool) {
+	if bytes.IndexByte(data, '\t') < 0 {
+		return data, false
+	}
+
+	out := make([]byte, 0, len(data))
+	changed := false
+	for len(data) > 0 {
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line = data[:i+1]
+		}
+		data = data[len(line):]
+
+		col := 0
+		for len(line) > 0 && (line[0] == ' ' || line[0] == '\t') {
+			if line[0] == '\t' {
+				n := width - col%width
+				out = append(out, bytes.Repeat([]byte(" "), n)...)
+				col += n
+				changed = true
+			} else {
+				out = append(out, ' ')
+				col++
+			}
+			line = line[1:]
+		}
+		out = append(out, line...)
+	}
+	return out, changed
+}
+
+func TranscodeUTF16(data []byte) ([]byte, bool, error) {
+	var bigEndian bool
+	switch {
+	case bytes.HasPrefix(data, utf16LEBOM):
+	case bytes.HasPrefix(data, utf16BEBOM):
+		bigEndian = true
+	default:
+		return data, false, nil
+	}
+
+	body := data[2:]
+	if len(body)%2 != 0 {
+		return data, false, fmt.Errorf("malformed UTF-16: odd number of bytes (%d) after the byte order mark", len(body))
+	}
+	units := make([]uint16, len(body)/2)
+	for i := range units {
+		lo, hi := body[2*i], body[2*i+1]
+		if bigEndian {
+			lo, hi = hi, lo
+		}
+		units[i] = uint16(hi)<<8 | uint16(lo)
+	}
+
+	out := make([]byte, 0, len(units))
+	for _, r := range utf16.Decode(units) {
+		out = utf8.AppendRune(out, r)
+	}
+	return out, true, nil
+}
+
+func NormalizeFileWith(path string, opts NormalizeOptions) ([]byte, NormalizeReport, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("reading file %s: %w", path, err)
+		return nil, NormalizeReport{}, fmt.Errorf("reading file %s: %w", path, err)
 	}
 
-	return NormalizeLineEndings(data), nil
+	normalized, report, err := NormalizeContent(path, data, opts)
+	if err != nil {
+		return nil, NormalizeReport{}, fmt.Errorf("normalizing %s: %w", path, err)
+	}
+	return normalized, report, nil
+}
+
+func NormalizeFile(path string) ([]byte, error) {
+	normalized, _, err := NormalizeFileWith(path, NormalizeOptions{})
+	return normalized, err
 }
@@ -3,6 +3,7 @@ package vm
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -70,6 +71,20 @@ func TestNormalizeFile(t *testing.T) {
 		}
 	})
 
+	t.Run("leaves binary file untouched", func(t *testing.T) {
+		path := filepath.Join(dir, "archive.tar.gz")
+		data := []byte("\x1f\x8b\x08\x00\r\n\x00\r\n")
+		os.WriteFile(path, data, 0644)
+
+		result, err := NormalizeFile(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(result) != string(data) {
+			t.Errorf("got %q, want %q", result, data)
+		}
+	})
+
 	t.Run("returns error for missing file", func(t *testing.T) {
 		_, err := NormalizeFile(filepath.Join(dir, "missing.txt"))
 		if err == nil {
@@ -77,3 +92,215 @@ func TestNormalizeFile(t *testing.T) {
 		}
 	})
 }
+
+func TestDetectBinary(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		data       []byte
+		extensions []string
+		want       bool
+	}{
+		{name: "plain text", path: "nginx.conf", data: []byte("worker_processes 4;\r\n"), want: false},
+		{name: "NUL byte", path: "data", data: []byte("abc\x00def\r\n"), want: true},
+		{name: "gzip magic", path: "dump", data: []byte("\x1f\x8b\x08\x00\r\n"), want: true},
+		{name: "zip magic", path: "bundle", data: []byte("PK\x03\x04\r\n"), want: true},
+		{name: "PNG magic", path: "logo", data: []byte("\x89PNG\r\n\x1a\n"), want: true},
+		{name: "ELF magic", path: "app", data: []byte("\x7fELF\x02\x01"), want: true},
+		{name: "known extension", path: "app.jar", data: []byte("text\r\n"), want: true},
+		{name: "extension case insensitive", path: "LOGO.PNG", data: []byte("text"), want: true},
+		{name: "configured extension", path: "model.onnx", data: []byte("text"), extensions: []string{".onnx"}, want: true},
+		{name: "empty file", path: "empty.txt", data: []byte{}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reason := DetectBinary(tt.path, tt.data, tt.extensions)
+			if got != tt.want {
+				t.Errorf("DetectBinary() = %v (%s), want %v", got, reason, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeContent_Modes(t *testing.T) {
+	text := []byte("a\r\nb\r\n")
+	binary := []byte("\x00a\r\nb\r\n")
+
+	tests := []struct {
+		name        string
+		data        []byte
+		mode        string
+		wantApplied bool
+		wantReport  string
+	}{
+		{name: "auto text", data: text, mode: "auto", wantApplied: true, wantReport: "applied: crlf"},
+		{name: "default is auto", data: binary, mode: "", wantApplied: false, wantReport: "skipped: binary (NUL byte)"},
+		{name: "auto binary", data: binary, mode: "auto", wantApplied: false, wantReport: "skipped: binary (NUL byte)"},
+		{name: "always binary", data: binary, mode: "always", wantApplied: true, wantReport: "applied: crlf"},
+		{name: "never text", data: text, mode: "never", wantApplied: false, wantReport: "skipped: normalize=never"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, report, err := NormalizeContent("file", tt.data, NormalizeOptions{Mode: tt.mode})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Applied != tt.wantApplied {
+				t.Errorf("got applied %v, want %v", report.Applied, tt.wantApplied)
+			}
+			if report.String() != tt.wantReport {
+				t.Errorf("got report %q, want %q", report.String(), tt.wantReport)
+			}
+			if !tt.wantApplied && string(result) != string(tt.data) {
+				t.Errorf("content changed although normalization was skipped: %q", result)
+			}
+			if tt.wantApplied && strings.Contains(string(result), "\r\n") {
+				t.Errorf("CRLF left in normalized content: %q", result)
+			}
+		})
+	}
+}
+
+func TestNormalizeContent_Fixes(t *testing.T) {
+	tests := []struct {
+		name      string
+		path      string
+		input     []byte
+		fixes     []string
+		expected  []byte
+		wantFixes string
+	}{
+		{
+			name:      "no extra fixes",
+			input:     []byte("\xef\xbb\xbf#!/bin/sh\recho"),
+			expected:  []byte("\xef\xbb\xbf#!/bin/sh\recho"),
+			wantFixes: "applied",
+		},
+		{
+			name:      "strip BOM",
+			input:     []byte("\xef\xbb\xbf#!/bin/sh\r\n"),
+			fixes:     []string{"bom"},
+			expected:  []byte("#!/bin/sh\n"),
+			wantFixes: "applied: bom, crlf",
+		},
+		{
+			name:      "lone CR",
+			input:     []byte("line1\rline2\r\nline3\r"),
+			fixes:     []string{"lone-cr"},
+			expected:  []byte("line1\nline2\nline3\n"),
+			wantFixes: "applied: crlf, lone-cr",
+		},
+		{
+			name:      "final newline",
+			input:     []byte("0 3 * * * /usr/bin/backup"),
+			fixes:     []string{"final-newline"},
+			expected:  []byte("0 3 * * * /usr/bin/backup\n"),
+			wantFixes: "applied: final-newline",
+		},
+		{
+			name:      "final newline already present",
+			input:     []byte("done\n"),
+			fixes:     []string{"final-newline"},
+			expected:  []byte("done\n"),
+			wantFixes: "applied",
+		},
+		{
+			name:      "final newline on empty file",
+			input:     []byte(""),
+			fixes:     []string{"final-newline"},
+			expected:  []byte(""),
+			wantFixes: "applied",
+		},
+		{
+			name:      "indentation tabs",
+			input:     []byte("server {\n\tlisten 80;\n  \tkey\tvalue\n}\n"),
+			fixes:     []string{"tabs"},
+			expected:  []byte("server {\n    listen 80;\n    key\tvalue\n}\n"),
+			wantFixes: "applied: tabs",
+		},
+		{
+			name:      "tabs kept in Makefile",
+			path:      "Makefile",
+			input:     []byte("all:\n\tgo build\n"),
+			fixes:     []string{"tabs"},
+			expected:  []byte("all:\n\tgo build\n"),
+			wantFixes: "applied",
+		},
+		{
+			name:      "UTF-16LE to UTF-8",
+			input:     []byte("\xff\xfeh\x00\xe9\x00\r\x00\n\x00"),
+			fixes:     []string{"utf16"},
+			expected:  []byte("h\xc3\xa9\n"),
+			wantFixes: "applied: utf16, crlf",
+		},
+		{
+			name:      "UTF-16BE to UTF-8",
+			input:     []byte("\xfe\xff\x00o\x00k"),
+			fixes:     []string{"utf16", "final-newline"},
+			expected:  []byte("ok\n"),
+			wantFixes: "applied: utf16, final-newline",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := tt.path
+			if path == "" {
+				path = "file"
+			}
+			result, report, err := NormalizeContent(path, tt.input, NormalizeOptions{Fixes: tt.fixes})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(result) != string(tt.expected) {
+				t.Errorf("got %q, want %q", result, tt.expected)
+			}
+			if report.String() != tt.wantFixes {
+				t.Errorf("got report %q, want %q", report.String(), tt.wantFixes)
+			}
+		})
+	}
+}
+
+func TestNormalizeContent_UTF16WithoutFixIsBinary(t *testing.T) {
+	input := []byte("\xff\xfeh\x00i\x00")
+
+	result, report, err := NormalizeContent("file", input, NormalizeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Applied {
+		t.Errorf("got report %q, want skipped", report.String())
+	}
+	if string(result) != string(input) {
+		t.Errorf("got %q, want unchanged input", result)
+	}
+}
+
+func TestNormalizeContent_OddUTF16(t *testing.T) {
+	_, _, err := NormalizeContent("file", []byte("\xff\xfeh\x00i"), NormalizeOptions{Fixes: []string{"utf16"}})
+	if err == nil || !strings.Contains(err.Error(), "odd number of bytes") {
+		t.Errorf("expected malformed UTF-16 error, got %v", err)
+	}
+}
+
+func TestExpandIndentTabs(t *testing.T) {
+	got, ok := ExpandIndentTabs([]byte("\ta\n \tb\n\t\tc"), 8)
+	if !ok || string(got) != "        a\n        b\n                c" {
+		t.Errorf("ExpandIndentTabs() = %q, %v", got, ok)
+	}
+	if _, ok := ExpandIndentTabs([]byte("no tabs\n"), 4); ok {
+		t.Error("expected no change")
+	}
+}
+
+func TestValidateNormalizeFixes(t *testing.T) {
+	if err := ValidateNormalizeFixes([]string{"bom", "lone-cr", "tabs", "final-newline", "utf16"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := ValidateNormalizeFixes([]string{"trailing-whitespace"}); err == nil {
+		t.Error("expected error for unknown fix")
+	}
+}
@@ -0,0 +1,126 @@
+package vm
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var paramPattern = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_-]+)\s*\}\}`)
+
+func expandParams(step TaskStep, params map[string]string) TaskStep {
+	if len(params) == 0 {
+		return step
+	}
+
+	expand := func(s string) string {
+		return paramPattern.ReplaceAllStringFunc(s, func(m string) string {
+			name := paramPattern.FindStringSubmatch(m)[1]
+			if value, ok := params[name]; ok {
+				return value
+			}
+			return m
+		})
+	}
+
+	step.Local = expand(step.Local)
+	step.Remote = expand(step.Remote)
+	step.Run = expand(step.Run)
+	step.Validate = expand(step.Validate)
+	step.Interpreter = expand(step.Interpreter)
+	step.URL = expand(step.URL)
+	if len(step.Args) > 0 {
+		args := make([]string, len(step.Args))
+		for i, arg := range step.Args {
+			args[i] = expand(arg)
+		}
+		step.Args = args
+	}
+	for _, cond := range []**Condition{&step.When, &step.Unless, &step.RetryUntil} {
+		if *cond != nil {
+			c := **cond
+			c.Match = expand(c.Match)
+			c.Probe = expand(c.Probe)
+			*cond = &c
+		}
+	}
+	if len(step.Params) > 0 {
+		expanded := make(map[string]string, len(step.Params))
+		for k, v := range step.Params {
+			expanded[k] = expand(v)
+		}
+		step.Params = expanded
+	}
+	return step
+}
+
+func stepParamRefs(step TaskStep) []string {
+	fields := append([]string{step.Local, step.Remote, step.Run, step.Validate, step.Interpreter, step.URL}, step.Args...)
+	for _, cond := range []*Condition{step.When, step.Unless, step.RetryUntil} {
+		if cond != nil {
+			fields = append(fields, cond.Match, cond.Probe)
+		}
+	}
+	for _, k := range sortedKeys(step.Params) {
+		fields = append(fields, step.Params[k])
+	}
+
+	var refs []string
+	for _, f := range fields {
+		for _, m := range paramPattern.FindAllStringSubmatch(f, -1) {
+			refs = appendUnique(refs, m[1])
+		}
+	}
+	return refs
+}
+
+func (c *ClientConfig) callTask(step TaskStep, stack []string, alias string) (Task, map[string]string, error) {
+	task, ok := c.task(step.Task)
+	if !ok {
+		return Task{}, nil, fmt.Errorf("unknown task: %s", step.Task)
+	}
+	for i, name := range stack {
+		if name == step.Task {
+			cycle := append(append([]string{}, stack[i:]...), step.Task)
+			return Task{}, nil, fmt.Errorf("task cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+	if alias != "" {
+		if err := c.checkAllowedHosts(step.Task, []string{alias}); err != nil {
+			return Task{}, nil, err
+		}
+	}
+
+	params := make(map[string]string, len(task.Params))
+	for k, v := range task.Params {
+		params[k] = v
+	}
+	for k, v := range step.Params {
+		if _, ok := task.Params[k]; !ok {
+			return Task{}, nil, fmt.Errorf("task %s has no parameter %q", step.Task, k)
+		}
+		params[k] = v
+	}
+	return task, params, nil
+}
+
+func (c *ClientConfig) taskCallCycle(name string, stack []string) []string {
+	for i, n := range stack {
+		if n == name {
+			return append(append([]string{}, stack[i:]...), name)
+		}
+	}
+	task, _ := c.task(name)
+	for _, step := range append(task.Steps[:len(task.Steps):len(task.Steps)], task.OnFailure...) {
+		if step.Type != "task" {
+			continue
+		}
+		if _, ok := c.Tasks[step.Task]; !ok {
+			continue
+		}
+		if cycle := c.taskCallCycle(step.Task, append(stack, name)); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package vm
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func compositionConfig() *ClientConfig {
+	return &ClientConfig{
+		Hosts: map[string]ServerConfig{"prod": {Host: "h", User: "u", Key: "k"}},
+		Tasks: map[string][]TaskStep{
+			"deploy-config": {{Type: "file", Local: "./{{name}}.conf", Remote: "/etc/{{name}}/{{name}}.conf"}},
+			"restart":       {{Type: "exec", Run: "systemctl reload {{service}}"}},
+			"full-release": {
+				{Type: "task", Task: "deploy-config"},
+				{Type: "task", Task: "restart", Params: map[string]string{"service": "{{svc}}"}},
+			},
+		},
+		TaskOptions: map[string]TaskOptions{
+			"deploy-config": {Params: map[string]string{"name": "nginx"}},
+			"restart":       {Params: map[string]string{"service": "nginx"}},
+			"full-release":  {Params: map[string]string{"svc": "php-fpm"}},
+		},
+	}
+}
+
+func TestExpandParams(t *testing.T) {
+	step := TaskStep{Type: "exec", Run: "systemctl {{ action }} {{service}} {{unknown}}"}
+	got := expandParams(step, map[string]string{"action": "reload", "service": "nginx"})
+	if got.Run != "systemctl reload nginx {{unknown}}" {
+		t.Errorf("expandParams() = %q", got.Run)
+	}
+}
+
+func TestTaskStepDryRun(t *testing.T) {
+	cfg := compositionConfig()
+
+	results := ExecuteRun(cfg, "full-release", []string{"prod"}, true, Confirmation{})
+	if len(results) != 1 {
+		t.Fatalf("got %d results", len(results))
+	}
+
+	got := strings.Join(planLabels(results[0].Steps, ""), "|")
+	want := "task:deploy-config|  file:/etc/nginx/nginx.conf|task:restart|  exec:systemctl reload php-fpm"
+	if got != want {
+		t.Errorf("plan = %q, want %q", got, want)
+	}
+}
+
+func TestValidateTaskSteps(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(cfg *ClientConfig)
+		want   string
+	}{
+		{name: "valid", modify: func(cfg *ClientConfig) {}},
+		{
+			name: "missing reference",
+			modify: func(cfg *ClientConfig) {
+				cfg.Tasks["broken"] = []TaskStep{{Type: "task", Task: "nope"}}
+			},
+			want: `tasks.broken[0].task: unknown task "nope"`,
+		},
+		{
+			name: "unknown parameter",
+			modify: func(cfg *ClientConfig) {
+				cfg.Tasks["broken"] = []TaskStep{{Type: "task", Task: "restart", Params: map[string]string{"srv": "x"}}}
+			},
+			want: `tasks.broken[0].params.srv: task "restart" has no parameter "srv"`,
+		},
+		{
+			name: "undefined placeholder",
+			modify: func(cfg *ClientConfig) {
+				cfg.Tasks["broken"] = []TaskStep{{Type: "exec", Run: "echo {{who}}"}}
+				cfg.TaskOptions["broken"] = TaskOptions{Params: map[string]string{"name": "x"}}
+			},
+			want: "tasks.broken.steps[0]: undefined parameter {{who}}",
+		},
+		{
+			name: "literal braces without params",
+			modify: func(cfg *ClientConfig) {
+				cfg.Tasks["inspect"] = []TaskStep{{Type: "exec", Run: "docker inspect -f '{{.State}}' app && echo {{ item }}"}}
+			},
+		},
+		{
+			name: "cycle",
+			modify: func(cfg *ClientConfig) {
+				cfg.Tasks["a"] = []TaskStep{{Type: "task", Task: "b"}}
+				cfg.Tasks["b"] = []TaskStep{{Type: "task", Task: "a"}}
+			},
+			want: "tasks.a: task cycle: a -> b -> a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := compositionConfig()
+			tt.modify(cfg)
+			err := cfg.Validate()
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCallTaskCycle(t *testing.T) {
+	cfg := compositionConfig()
+	_, _, err := cfg.callTask(TaskStep{Type: "task", Task: "restart"}, []string{"restart"}, "")
+	if err == nil || !strings.Contains(err.Error(), "task cycle: restart -> restart") {
+		t.Errorf("callTask() error = %v", err)
+	}
+}
+
+func TestNestedTaskAllowedHosts(t *testing.T) {
+	cfg := compositionConfig()
+	cfg.Hosts["staging"] = ServerConfig{Host: "s", User: "u", Key: "k"}
+	cfg.TaskOptions["restart"] = TaskOptions{
+		Params:       cfg.TaskOptions["restart"].Params,
+		AllowedHosts: []string{"staging"},
+	}
+
+	_, _, err := cfg.callTask(TaskStep{Type: "task", Task: "restart"}, []string{"full-release"}, "prod")
+	if !errors.Is(err, ErrHostNotAllowed) {
+		t.Errorf("callTask() error = %v, want ErrHostNotAllowed", err)
+	}
+	if _, _, err := cfg.callTask(TaskStep{Type: "task", Task: "restart"}, []string{"full-release"}, "staging"); err != nil {
+		t.Errorf("callTask() on allowed host: %v", err)
+	}
+
+	results := ExecuteRun(cfg, "full-release", []string{"prod"}, true, Confirmation{})
+	if len(results) != 1 || len(results[0].Steps) != 2 {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	if sr := results[0].Steps[1]; sr.Status != "error" || !strings.Contains(sr.Error, `task "restart" refuses to run on prod`) {
+		t.Errorf("dry-run nested step = %+v", sr)
+	}
+}
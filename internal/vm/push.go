@@ -3,14 +3,59 @@ package vm
 import "fmt"
 
 type PushResult struct {
-	Server string `json:"server"`
-	File   string `json:"file"`
-	Status string `json:"status"`
-	Backup string `json:"backup,omitempty"`
-	Error  string `json:"error,omitempty"`
+	Server       string `json:"server"`
+	File         string `json:"file"`
+	Status       string `json:"status"`
+	Backup       string `json:"backup,omitempty"`
+	RemoteBackup string `json:"remote_backup,omitempty"`
+	Normalize    string `json:"normalize,omitempty"`
+	Error        string `json:"error,omitempty"`
 }
 
-func ExecutePush(cfg *ClientConfig, alias, localPath, remotePath string, dryRun bool) PushResult {
+func ExecutePush(cfg *ClientConfig, targets []string, localPath, remotePath string, dryRun bool, confirm Confirmation) []PushResult {
+	var results []PushResult
+	fail := func(servers []string, err error) []PushResult {
+		for _, server := range servers {
+			results = append(results, PushResult{Server: server, File: remotePath, Status: "error", Error: err.Error()})
+		}
+		return results
+	}
+
+	aliases, err := cfg.ResolveTargets(targets)
+	if err != nil {
+		return fail(targets, err)
+	}
+
+	if dryRun {
+		for _, alias := range aliases {
+			results = append(results, PushResult{Server: alias, File: remotePath, Status: "dry-run"})
+		}
+		return results
+	}
+
+	plan := []string{fmt.Sprintf("upload %s to %s", localPath, remotePath)}
+	if err := cfg.ConfirmProtected(aliases, "push", plan, confirm); err != nil {
+		return fail(aliases, err)
+	}
+
+	normalized, report, err := NormalizeFileWith(localPath, cfg.normalizeOptions("", nil))
+	if err != nil {
+		return fail(aliases, fmt.Errorf("normalization failed: %v", err))
+	}
+	if err := CheckSyntax(localPath, normalized); err != nil {
+		return fail(aliases, fmt.Errorf("validation failed: %v", err))
+	}
+
+	for _, alias := range aliases {
+		result := pushToServer(cfg, alias, normalized, remotePath)
+		result.Normalize = report.String()
+		results = append(results, result)
+	}
+
+	return results
+}
+
+func pushToServer(cfg *ClientConfig, alias string, normalized []byte, remotePath string) PushResult {
 	result := PushResult{
 		Server: alias,
 		File:   remotePath,
@@ -23,13 +68,7 @@ func ExecutePush(cfg *ClientConfig, alias, localPath, remotePath string, dryRun
 		return result
 	}
 
-	if dryRun {
-		result.Status = "dry-run"
-		return result
-	}
-
-	auth := SSHAuth{KeyPath: server.Key, Password: server.Password}
-	client, err := NewSSHClient(server.Host, server.User, auth)
+	client, err := server.connect()
 	if err != nil {
 		result.Status = "error"
 		result.Error = fmt.Sprintf("connection failed: %v", err)
@@ -45,20 +84,14 @@ func ExecutePush(cfg *ClientConfig, alias, localPath, remotePath string, dryRun
 	}
 	defer transfer.Close()
 
-	backupPath, err := CreateBackup(transfer, remotePath, server.Host)
+	record, err := CreateBackup(transfer, remotePath, server.Host, cfg.Backup)
 	if err != nil {
 		result.Status = "error"
 		result.Error = fmt.Sprintf("backup failed (aborting): %v", err)
 		return result
 	}
-	result.Backup = backupPath
-
-	normalized, err := NormalizeFile(localPath)
-	if err != nil {
-		result.Status = "error"
-		result.Error = fmt.Sprintf("normalization failed: %v", err)
-		return result
-	}
+	result.Backup = record.Local
+	result.RemoteBackup = record.Remote
 
 	if err := transfer.UploadBytes(normalized, remotePath); err != nil {
 		result.Status = "error"
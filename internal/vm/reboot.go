@@ -0,0 +1,153 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRebootCommand  = "reboot"
+	defaultRebootInterval = 5 * time.Second
+	defaultRebootTimeout  = 10 * time.Minute
+	bootIDPath            = "/proc/sys/kernel/random/boot_id"
+)
+
+func rebootCommand(run string) string {
+	if run == "" {
+		run = defaultRebootCommand
+	}
+	return fmt.Sprintf("nohup sh -c %s >/dev/null 2>&1 &", shellQuote("sleep 1; "+run))
+}
+
+func readBootID(client *SSHClient) (string, error) {
+	output, err := client.Execute("cat " + bootIDPath)
+	if err != nil {
+		return "", fmt.Errorf("reading boot ID: %w", err)
+	}
+	return strings.TrimSpace(output), nil
+}
+
+func stillConnected(client *SSHClient, timeout time.Duration) bool {
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := client.Client.SendRequest("keepalive@openssh.com", true, nil)
+		done <- err
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err == nil
+	case <-timer.C:
+		return false
+	}
+}
+
+func (s *runSession) checkRebootAllowed(step TaskStep) error {
+	if step.Sudo {
+		if _, err := s.server.executeSudo(s.client, "true"); err != nil {
+			return fmt.Errorf("sudo check failed: %w", err)
+		}
+		return nil
+	}
+	if step.Run != "" {
+		return nil
+	}
+	uid, err := s.client.Execute("id -u")
+	if err != nil {
+		return fmt.Errorf("checking user: %w", err)
+	}
+	if uid != "0" {
+		return fmt.Errorf("%s is not root and cannot reboot; set \"sudo\": true", s.server.User)
+	}
+	return nil
+}
+
+func (s *runSession) executeRebootStep(step TaskStep) StepResult {
+	sr := StepResult{Step: stepLabel(step)}
+	fail := func(format string, args ...any) StepResult {
+		sr.Status = "error"
+		sr.Error = s.server.redact(fmt.Sprintf(format, args...))
+		return sr
+	}
+
+	var oldBootID string
+	if step.CheckBootID {
+		id, err := readBootID(s.client)
+		if err != nil {
+			return fail("%v", err)
+		}
+		oldBootID = id
+	}
+	if err := s.checkRebootAllowed(step); err != nil {
+		return fail("%v", err)
+	}
+
+	start := now()
+	cmd := rebootCommand(step.Run)
+	var err error
+	if step.Sudo {
+		_, err = s.server.executeSudo(s.client, cmd)
+	} else {
+		_, err = s.client.Execute(cmd)
+	}
+	if err != nil {
+		return fail("issuing reboot: %v", err)
+	}
+
+	interval := step.pollInterval(defaultRebootInterval)
+	timeout := step.pollTimeout(defaultRebootTimeout)
+
+	_, _, err = waitFor(func(probeTimeout time.Duration) error {
+		if stillConnected(s.client, probeTimeout) {
+			return errors.New("host is still connected")
+		}
+		return nil
+	}, interval, timeout)
+	if err != nil {
+		return fail("host did not go down within %s: %v", timeout, err)
+	}
+
+	reopenSFTP := s.transfer != nil
+	if reopenSFTP {
+		s.transfer.Close()
+		s.transfer = nil
+	}
+	s.client.Close()
+
+	probes, _, err := waitFor(func(time.Duration) error {
+		client, err := s.server.connect()
+		if err != nil {
+			return err
+		}
+		if step.CheckBootID {
+			id, err := readBootID(client)
+			if err == nil && id == oldBootID {
+				err = fmt.Errorf("boot ID unchanged (%s), host did not reboot", id)
+			}
+			if err != nil {
+				client.Close()
+				return err
+			}
+		}
+		s.client = client
+		return nil
+	}, interval, timeout-now().Sub(start))
+	sr.Elapsed = now().Sub(start).Round(time.Millisecond).String()
+	if err != nil {
+		return fail("host not back after %s (%d connection attempts): %v", sr.Elapsed, probes, err)
+	}
+
+	if reopenSFTP {
+		if _, err := s.sftp(); err != nil {
+			return fail("SFTP failed after reboot: %v", err)
+		}
+	}
+
+	sr.Status = "ok"
+	sr.Output = fmt.Sprintf("host back after %s", sr.Elapsed)
+	return sr
+}
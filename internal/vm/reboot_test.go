@@ -0,0 +1,46 @@
+package vm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRebootCommand(t *testing.T) {
+	tests := []struct {
+		run  string
+		want string
+	}{
+		{run: "", want: `nohup sh -c 'sleep 1; reboot' >/dev/null 2>&1 &`},
+		{run: "shutdown -r now 'kernel update'", want: `nohup sh -c 'sleep 1; shutdown -r now '"'"'kernel update'"'"'' >/dev/null 2>&1 &`},
+	}
+
+	for _, tt := range tests {
+		if got := rebootCommand(tt.run); got != tt.want {
+			t.Errorf("rebootCommand(%q) = %s, want %s", tt.run, got, tt.want)
+		}
+	}
+}
+
+func TestRebootStepValidation(t *testing.T) {
+	cfg := &ClientConfig{
+		Hosts: map[string]ServerConfig{"prod": {Host: "h", User: "u", Key: "k"}},
+		Tasks: map[string][]TaskStep{"t": {
+			{Type: "reboot", Sudo: true, CheckBootID: true, Timeout: "15m"},
+			{Type: "reboot", Interval: "-1s"},
+		}},
+	}
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected validation error")
+	}
+	if !strings.Contains(err.Error(), `tasks.t[1].interval: invalid duration "-1s"`) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if strings.Contains(err.Error(), "tasks.t[0]") {
+		t.Errorf("valid reboot step rejected: %v", err)
+	}
+
+	if got := stepLabel(TaskStep{Type: "reboot"}); got != "reboot" {
+		t.Errorf("stepLabel() = %s", got)
+	}
+}
@@ -0,0 +1,129 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func stubSleep(t *testing.T) *[]time.Duration {
+	t.Helper()
+	var delays []time.Duration
+	orig := sleep
+	sleep = func(d time.Duration) { delays = append(delays, d) }
+	t.Cleanup(func() { sleep = orig })
+	return &delays
+}
+
+func TestRunWithRetries(t *testing.T) {
+	delays := stubSleep(t)
+	sess := &runSession{cfg: &ClientConfig{}}
+
+	sr := sess.runWithRetries(TaskStep{Type: "bogus", Retries: 3, RetryDelay: "500ms"}, nil)
+	if sr.Status != "error" || sr.Attempts != 4 {
+		t.Errorf("got status %s after %d attempts, want error after 4", sr.Status, sr.Attempts)
+	}
+	want := []time.Duration{500 * time.Millisecond, time.Second, 2 * time.Second}
+	if len(*delays) != len(want) {
+		t.Fatalf("delays = %v, want %v", *delays, want)
+	}
+	for i := range want {
+		if (*delays)[i] != want[i] {
+			t.Errorf("delays = %v, want %v", *delays, want)
+			break
+		}
+	}
+
+	*delays = nil
+	sr = sess.runWithRetries(TaskStep{Type: "bogus", Retries: 1, Backoff: 1, IgnoreErrors: true}, nil)
+	if sr.Status != "ignored" || sr.Error == "" {
+		t.Errorf("ignore_errors: got %+v", sr)
+	}
+	if len(*delays) != 1 || (*delays)[0] != defaultRetryDelay {
+		t.Errorf("delays = %v, want [%v]", *delays, defaultRetryDelay)
+	}
+	*delays = nil
+	sess.runWithRetries(TaskStep{Type: "bogus", Retries: 4, RetryDelay: "20s"}, nil)
+	want = []time.Duration{20 * time.Second, 40 * time.Second, time.Minute, time.Minute}
+	if fmt.Sprint(*delays) != fmt.Sprint(want) {
+		t.Errorf("capped delays = %v, want %v", *delays, want)
+	}
+}
+
+func TestRunTaskOnFailure(t *testing.T) {
+	stubSleep(t)
+	sess := &runSession{cfg: &ClientConfig{}}
+	task := Task{
+		Steps: []TaskStep{
+			{Type: "bogus", IgnoreErrors: true},
+			{Type: "broken"},
+			{Type: "never-reached"},
+		},
+		TaskOptions: TaskOptions{OnFailure: []TaskStep{{Type: "enable-node"}}},
+	}
+
+	steps, onFailure, ok := sess.runTask(task, nil, []string{"deploy"})
+	if ok {
+		t.Fatal("expected failure")
+	}
+	var statuses []string
+	for _, s := range steps {
+		statuses = append(statuses, s.Status)
+	}
+	if strings.Join(statuses, " ") != "ignored error" {
+		t.Errorf("statuses = %v", statuses)
+	}
+	if len(onFailure) != 1 || onFailure[0].Step != "enable-node" {
+		t.Errorf("on_failure = %+v", onFailure)
+	}
+}
+
+func TestValidateRetries(t *testing.T) {
+	cfg := &ClientConfig{
+		Hosts: map[string]ServerConfig{"prod": {Host: "h", User: "u", Key: "k"}},
+		Tasks: map[string][]TaskStep{"t": {
+			{Type: "exec", Run: "curl -f localhost", Retries: -1, RetryDelay: "soon", Backoff: 0.5, RetryUntil: &Condition{}},
+		}},
+		TaskOptions: map[string]TaskOptions{"t": {OnFailure: []TaskStep{{Type: "exec"}}}},
+	}
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for _, want := range []string{
+		"tasks.t.steps[0].retries",
+		"tasks.t.steps[0].retry_delay",
+		"tasks.t.steps[0].backoff",
+		"tasks.t.steps[0].retry_until",
+		"tasks.t.on_failure[0].run",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+}
+
+func TestRetryUntilParams(t *testing.T) {
+	step := TaskStep{Type: "exec", Run: "systemctl restart app", RetryUntil: &Condition{Probe: "curl -f {{url}}"}}
+	got := expandParams(step, map[string]string{"url": "http://localhost:8080"})
+	if got.RetryUntil.Probe != "curl -f http://localhost:8080" {
+		t.Errorf("retry_until probe = %q", got.RetryUntil.Probe)
+	}
+	if step.RetryUntil.Probe != "curl -f {{url}}" {
+		t.Errorf("expandParams modified the original condition: %q", step.RetryUntil.Probe)
+	}
+
+	cfg := &ClientConfig{
+		Hosts: map[string]ServerConfig{"prod": {Host: "h", User: "u", Key: "k"}},
+		Tasks: map[string][]TaskStep{"t": {
+			{Type: "exec", Run: "systemctl restart app", RetryUntil: &Condition{Probe: "curl -f {{health}}"}},
+		}},
+		TaskOptions: map[string]TaskOptions{"t": {Params: map[string]string{"url": "http://localhost"}}},
+	}
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "undefined parameter {{health}}") {
+		t.Errorf("Validate() = %v, want undefined parameter {{health}}", err)
+	}
+}
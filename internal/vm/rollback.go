@@ -0,0 +1,98 @@
+package vm
+
+import "fmt"
+
+type RollbackResult struct {
+	Server string `json:"server"`
+	File   string `json:"file"`
+	Status string `json:"status"`
+	Backup string `json:"backup,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+func ExecuteRollback(cfg *ClientConfig, targets []string, remotePath string, confirm Confirmation) []RollbackResult {
+	var results []RollbackResult
+
+	aliases, err := cfg.ResolveTargets(targets)
+	if err == nil {
+		plan := []string{fmt.Sprintf("restore %s from the latest backup", remotePath)}
+		err = cfg.ConfirmProtected(aliases, "rollback", plan, confirm)
+	}
+	if err != nil {
+		for _, target := range targets {
+			results = append(results, RollbackResult{Server: target, File: remotePath, Status: "error", Error: err.Error()})
+		}
+		return results
+	}
+
+	for _, alias := range aliases {
+		result := RollbackResult{
+			Server: alias,
+			File:   remotePath,
+		}
+
+		server, err := cfg.ResolveHost(alias)
+		if err != nil {
+			result.Status = "error"
+			result.Error = err.Error()
+			results = append(results, result)
+			continue
+		}
+
+		results = append(results, rollbackOnServer(server, cfg.Backup, result))
+	}
+
+	return results
+}
+
+func ExecuteRollbackDirect(server ServerConfig, backup BackupConfig, remotePath string, confirm Confirmation) RollbackResult {
+	result := RollbackResult{
+		Server: server.Host,
+		File:   remotePath,
+	}
+
+	plan := []string{fmt.Sprintf("restore %s from the latest backup", remotePath)}
+	servers := map[string]ServerConfig{server.Host: server}
+	if err := confirmProtected([]string{server.Host}, servers, "rollback", plan, confirm); err != nil {
+		result.Status = "error"
+		result.Error = err.Error()
+		return result
+	}
+
+	return rollbackOnServer(server, backup, result)
+}
+
+func rollbackOnServer(server ServerConfig, backup BackupConfig, result RollbackResult) RollbackResult {
+	backupPath, err := FindLatestBackup(backup, server.Host, result.File)
+	if err != nil {
+		result.Status = "error"
+		result.Error = err.Error()
+		return result
+	}
+	result.Backup = backupPath
+
+	client, err := server.connect()
+	if err != nil {
+		result.Status = "error"
+		result.Error = fmt.Sprintf("connection failed: %v", err)
+		return result
+	}
+	defer client.Close()
+
+	transfer, err := NewSFTPTransfer(client)
+	if err != nil {
+		result.Status = "error"
+		result.Error = fmt.Sprintf("SFTP failed: %v", err)
+		return result
+	}
+	defer transfer.Close()
+
+	if err := RestoreBackup(transfer, backup, backupPath, result.File); err != nil {
+		result.Status = "error"
+		result.Error = fmt.Sprintf("restore failed: %v", err)
+		return result
+	}
+
+	result.Status = "ok"
+	return result
+}
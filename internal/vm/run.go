@@ -1,30 +1,70 @@
 package vm
 
-import "fmt"
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRetryDelay   = time.Second
+	defaultRetryBackoff = 2.0
+	maxRetryDelay       = time.Minute
+)
+
+var sleep = time.Sleep
 
 type StepResult struct {
-	Step   string `json:"step"`
-	Status string `json:"status"`
-	Backup string `json:"backup,omitempty"`
-	Output string `json:"output,omitempty"`
-	Error  string `json:"error,omitempty"`
+	Step         string       `json:"step"`
+	Status       string       `json:"status"`
+	Backup       string       `json:"backup,omitempty"`
+	RemoteBackup string       `json:"remote_backup,omitempty"`
+	Normalize    string       `json:"normalize,omitempty"`
+	Output       string       `json:"output,omitempty"`
+	Reason       string       `json:"reason,omitempty"`
+	Error        string       `json:"error,omitempty"`
+	Attempts     int          `json:"attempts,omitempty"`
+	Files        []string     `json:"files,omitempty"`
+	Elapsed      string       `json:"elapsed,omitempty"`
+	Steps        []StepResult `json:"steps,omitempty"`
+	OnFailure    []StepResult `json:"on_failure,omitempty"`
 }
 
 type RunResult struct {
-	Server string       `json:"server"`
-	Task   string       `json:"task"`
-	Steps  []StepResult `json:"steps"`
-	Status string       `json:"status"`
+	Server    string       `json:"server"`
+	Task      string       `json:"task"`
+	Run       string       `json:"run,omitempty"`
+	Steps     []StepResult `json:"steps"`
+	OnFailure []StepResult `json:"on_failure,omitempty"`
+	Status    string       `json:"status"`
 }
 
-func ExecuteRun(cfg *ClientConfig, taskName string, aliases []string, dryRun bool) []RunResult {
+func ExecuteRun(cfg *ClientConfig, taskName string, targets []string, dryRun bool, confirm Confirmation) []RunResult {
 	var results []RunResult
 
 	steps, err := cfg.ResolveTask(taskName)
+	var aliases []string
+	if err == nil {
+		aliases, err = cfg.TaskTargets(taskName, targets)
+	}
+	if err == nil && !dryRun {
+		plan := planLabels(cfg.dryRunSteps(steps, cfg.TaskOptions[taskName].Params, []string{taskName}, nil), "")
+		err = cfg.ConfirmProtected(aliases, "run "+taskName, plan, confirm)
+	}
+	backup := cfg.Backup
+	if err == nil {
+		backup.RunID, err = newRunID()
+	}
 	if err != nil {
-		for _, alias := range aliases {
+		if len(targets) == 0 {
+			targets = []string{""}
+		}
+		for _, target := range targets {
 			results = append(results, RunResult{
-				Server: alias,
+				Server: target,
 				Task:   taskName,
 				Status: "error",
 				Steps: []StepResult{{
@@ -38,19 +78,33 @@ func ExecuteRun(cfg *ClientConfig, taskName string, aliases []string, dryRun boo
 	}
 
 	for _, alias := range aliases {
-		result := executeRunOnServer(cfg, alias, taskName, steps, dryRun)
+		result := executeRunOnServer(cfg, alias, taskName, backup, len(aliases) > 1, dryRun)
 		results = append(results, result)
 	}
 
 	return results
 }
 
-func executeRunOnServer(cfg *ClientConfig, alias, taskName string, steps []TaskStep, dryRun bool) RunResult {
+type runSession struct {
+	cfg       *ClientConfig
+	alias     string
+	multiHost bool
+	server    ServerConfig
+	backup    BackupConfig
+	backups   map[string]BackupRecord
+	client    *SSHClient
+	transfer  *SFTPTransfer
+}
+
+func executeRunOnServer(cfg *ClientConfig, alias, taskName string, backup BackupConfig, multiHost, dryRun bool) RunResult {
 	result := RunResult{
 		Server: alias,
 		Task:   taskName,
+		Run:    backup.RunID,
 	}
 
+	task, _ := cfg.task(taskName)
+
 	server, err := cfg.ResolveHost(alias)
 	if err != nil {
 		result.Status = "error"
@@ -63,18 +117,12 @@ func executeRunOnServer(cfg *ClientConfig, alias, taskName string, steps []TaskS
 	}
 
 	if dryRun {
-		for _, step := range steps {
-			result.Steps = append(result.Steps, StepResult{
-				Step:   stepLabel(step),
-				Status: "dry-run",
-			})
-		}
+		result.Steps = cfg.dryRunSteps(task.Steps, task.Params, []string{taskName}, hostVars(alias, server))
 		result.Status = "dry-run"
 		return result
 	}
 
-	auth := SSHAuth{KeyPath: server.Key, Password: server.Password}
-	client, err := NewSSHClient(server.Host, server.User, auth)
+	client, err := server.connect()
 	if err != nil {
 		result.Status = "error"
 		result.Steps = []StepResult{{
@@ -84,76 +132,238 @@ func executeRunOnServer(cfg *ClientConfig, alias, taskName string, steps []TaskS
 		}}
 		return result
 	}
-	defer client.Close()
 
-	var transfer *SFTPTransfer
-	needsSFTP := false
-	for _, step := range steps {
-		if step.Type == "file" {
-			needsSFTP = true
-			break
-		}
+	sess := &runSession{cfg: cfg, alias: alias, multiHost: multiHost, server: server, backup: backup, backups: map[string]BackupRecord{}, client: client}
+	defer sess.close()
+
+	steps, onFailure, ok := sess.runTask(task, task.Params, []string{taskName})
+	result.Steps = steps
+	result.OnFailure = onFailure
+	if ok {
+		result.Status = "ok"
+	} else {
+		result.Status = "error"
 	}
 
-	if needsSFTP {
-		transfer, err = NewSFTPTransfer(client)
+	return result
+}
+
+func (s *runSession) sftp() (*SFTPTransfer, error) {
+	if s.transfer == nil {
+		transfer, err := NewSFTPTransfer(s.client)
 		if err != nil {
-			result.Status = "error"
-			result.Steps = []StepResult{{
-				Step:   "sftp",
-				Status: "error",
-				Error:  fmt.Sprintf("SFTP failed: %v", err),
-			}}
-			return result
+			return nil, err
 		}
-		defer transfer.Close()
+		s.transfer = transfer
+	}
+	return s.transfer, nil
+}
+
+func (s *runSession) close() {
+	if s.transfer != nil {
+		s.transfer.Close()
 	}
+	s.client.Close()
+}
 
-	allOK := true
+func (s *runSession) runSteps(steps []TaskStep, params map[string]string, stack []string) ([]StepResult, bool) {
+	var results []StepResult
 	for _, step := range steps {
-		var stepResult StepResult
+		step = expandParams(step, params)
 
-		switch step.Type {
-		case "file":
-			stepResult = executeFileStep(transfer, step, server.Host)
-		case "exec":
-			stepResult = executeExecStep(client, step)
+		reason, err := s.skipReason(step)
+		if err != nil {
+			results = append(results, StepResult{Step: stepLabel(step), Status: "error", Error: err.Error()})
+			return results, false
+		}
+		if reason != "" {
+			results = append(results, StepResult{Step: stepLabel(step), Status: "skipped", Reason: reason})
+			continue
 		}
 
-		result.Steps = append(result.Steps, stepResult)
+		stepResult := s.runWithRetries(step, stack)
+		results = append(results, stepResult)
 
 		if stepResult.Status == "error" {
-			allOK = false
+			return results, false
+		}
+	}
+	return results, true
+}
+
+func (s *runSession) runTask(task Task, params map[string]string, stack []string) ([]StepResult, []StepResult, bool) {
+	results, ok := s.runSteps(task.Steps, params, stack)
+	if ok || len(task.OnFailure) == 0 {
+		return results, nil, ok
+	}
+	onFailure, _ := s.runSteps(task.OnFailure, params, stack)
+	return results, onFailure, false
+}
+
+func (s *runSession) runWithRetries(step TaskStep, stack []string) StepResult {
+	delay := step.retryDelay()
+	backoff := step.Backoff
+	if backoff == 0 {
+		backoff = defaultRetryBackoff
+	}
+
+	var sr StepResult
+	for attempt := 1; ; attempt++ {
+		sr = s.executeStep(step, stack)
+		if sr.Status != "error" && step.RetryUntil != nil {
+			ok, err := s.evalCondition(step.RetryUntil)
+			switch {
+			case err != nil:
+				sr.Status = "error"
+				sr.Error = fmt.Sprintf("evaluating retry_until: %v", err)
+			case !ok:
+				sr.Status = "error"
+				sr.Error = "retry_until " + describeCondition(step.RetryUntil) + " is still false"
+			}
+		}
+		if attempt > 1 {
+			sr.Attempts = attempt
+		}
+		if sr.Status != "error" || attempt > step.Retries {
 			break
 		}
+		sleep(delay)
+		if next := time.Duration(float64(delay) * backoff); next > maxRetryDelay {
+			delay = max(delay, maxRetryDelay)
+		} else {
+			delay = next
+		}
 	}
 
-	if allOK {
-		result.Status = "ok"
-	} else {
-		result.Status = "error"
+	if sr.Status == "error" && step.IgnoreErrors {
+		sr.Status = "ignored"
 	}
+	return sr
+}
 
-	return result
+func (s *runSession) executeStep(step TaskStep, stack []string) StepResult {
+	switch step.Type {
+	case "file":
+		transfer, err := s.sftp()
+		if err != nil {
+			return StepResult{Step: stepLabel(step), Status: "error", Error: fmt.Sprintf("SFTP failed: %v", err)}
+		}
+		return executeFileStep(s.client, transfer, step, s.server.Host, s.backup, s.backups, s.cfg.normalizeOptions(step.Normalize, step.Fixes))
+	case "exec":
+		return executeExecStep(s.client, step, s.server)
+	case "fetch":
+		transfer, err := s.sftp()
+		if err != nil {
+			return StepResult{Step: stepLabel(step), Status: "error", Error: fmt.Sprintf("SFTP failed: %v", err)}
+		}
+		return executeFetchStep(transfer, step, fetchDestination(step.Local, s.alias, s.multiHost))
+	case "wait":
+		return s.executeWaitStep(step)
+	case "reboot":
+		return s.executeRebootStep(step)
+	case "script":
+		transfer, err := s.sftp()
+		if err != nil {
+			return StepResult{Step: stepLabel(step), Status: "error", Error: fmt.Sprintf("SFTP failed: %v", err)}
+		}
+		return executeScriptStep(s.client, transfer, step, s.server, s.cfg.normalizeOptions(step.Normalize, step.Fixes))
+	case "task":
+		return s.runTaskStep(step, stack)
+	default:
+		return StepResult{Step: stepLabel(step), Status: "error", Error: fmt.Sprintf("unknown step type %q", step.Type)}
+	}
 }
 
-func executeFileStep(transfer *SFTPTransfer, step TaskStep, host string) StepResult {
+func (step TaskStep) retryDelay() time.Duration {
+	if d, err := time.ParseDuration(step.RetryDelay); err == nil {
+		return d
+	}
+	return defaultRetryDelay
+}
+
+func (s *runSession) runTaskStep(step TaskStep, stack []string) StepResult {
 	sr := StepResult{Step: stepLabel(step)}
 
-	backupPath, err := CreateBackup(transfer, step.Remote, host)
+	task, params, err := s.cfg.callTask(step, stack, s.alias)
 	if err != nil {
 		sr.Status = "error"
-		sr.Error = fmt.Sprintf("backup failed (aborting): %v", err)
+		sr.Error = err.Error()
 		return sr
 	}
-	sr.Backup = backupPath
 
-	normalized, err := NormalizeFile(step.Local)
+	nested, onFailure, ok := s.runTask(task, params, append(stack, step.Task))
+	sr.Steps = nested
+	sr.OnFailure = onFailure
+	if ok {
+		sr.Status = "ok"
+	} else {
+		sr.Status = "error"
+		sr.Error = "nested task failed"
+	}
+	return sr
+}
+
+func (c *ClientConfig) dryRunSteps(steps []TaskStep, params map[string]string, stack []string, vars map[string]string) []StepResult {
+	var results []StepResult
+	for _, step := range steps {
+		step = expandParams(step, params)
+		sr := StepResult{Step: stepLabel(step), Status: "dry-run"}
+		if reason := dryRunSkipReason(step, vars); reason != "" {
+			sr.Status = "skipped"
+			sr.Reason = reason
+		} else if step.Type == "task" {
+			task, taskParams, err := c.callTask(step, stack, vars["alias"])
+			if err != nil {
+				sr.Status = "error"
+				sr.Error = err.Error()
+			} else {
+				sr.Steps = c.dryRunSteps(task.Steps, taskParams, append(stack, step.Task), vars)
+			}
+		}
+		results = append(results, sr)
+	}
+	return results
+}
+
+func executeFileStep(client *SSHClient, transfer *SFTPTransfer, step TaskStep, host string, backup BackupConfig, backups map[string]BackupRecord, normalize NormalizeOptions) StepResult {
+	sr := StepResult{Step: stepLabel(step)}
+
+	normalized, report, err := NormalizeFileWith(step.Local, normalize)
 	if err != nil {
 		sr.Status = "error"
 		sr.Error = fmt.Sprintf("normalization failed: %v", err)
 		return sr
 	}
+	sr.Normalize = report.String()
+
+	if err := CheckSyntax(step.Local, normalized); err != nil {
+		sr.Status = "error"
+		sr.Error = fmt.Sprintf("validation failed: %v", err)
+		return sr
+	}
+
+	if step.Validate != "" {
+		output, err := validateStaged(client, transfer, normalized, step.Remote, step.Validate)
+		if err != nil {
+			sr.Status = "error"
+			sr.Output = output
+			sr.Error = fmt.Sprintf("validation failed: %v", err)
+			return sr
+		}
+	}
+
+	record, ok := backups[step.Remote]
+	if !ok {
+		record, err = CreateBackup(transfer, step.Remote, host, backup)
+		if err != nil {
+			sr.Status = "error"
+			sr.Error = fmt.Sprintf("backup failed (aborting): %v", err)
+			return sr
+		}
+		backups[step.Remote] = record
+	}
+	sr.Backup = record.Local
+	sr.RemoteBackup = record.Remote
 
 	if err := transfer.UploadBytes(normalized, step.Remote); err != nil {
 		sr.Status = "error"
@@ -165,15 +375,41 @@ func executeFileStep(transfer *SFTPTransfer, step TaskStep, host string) StepRes
 	return sr
 }
 
-func executeExecStep(client *SSHClient, step TaskStep) StepResult {
+func validateStaged(client *SSHClient, transfer *SFTPTransfer, data []byte, remotePath, validate string) (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", fmt.Errorf("staging %s: %w", remotePath, err)
+	}
+	staged := path.Join(path.Dir(remotePath), "."+path.Base(remotePath)+".onevm-"+hex.EncodeToString(suffix))
+
+	if err := transfer.UploadBytes(data, staged); err != nil {
+		return "", fmt.Errorf("staging %s: %w", staged, err)
+	}
+	defer transfer.Remove(staged)
+
+	output, err := client.Execute(strings.ReplaceAll(validate, "%s", shellQuote(staged)))
+	if err != nil {
+		return output, fmt.Errorf("remote check rejected the file: %w", err)
+	}
+
+	return output, nil
+}
+
+func executeExecStep(client *SSHClient, step TaskStep, server ServerConfig) StepResult {
 	sr := StepResult{Step: stepLabel(step)}
 
-	output, err := client.Execute(step.Run)
-	sr.Output = output
+	var output string
+	var err error
+	if step.Sudo {
+		output, err = server.executeSudo(client, step.Run)
+	} else {
+		output, err = client.Execute(step.Run)
+	}
+	sr.Output = server.redact(output)
 
 	if err != nil {
 		sr.Status = "error"
-		sr.Error = fmt.Sprintf("command failed: %v", err)
+		sr.Error = server.redact(fmt.Sprintf("command failed: %v", err))
 		return sr
 	}
 
@@ -181,13 +417,40 @@ func executeExecStep(client *SSHClient, step TaskStep) StepResult {
 	return sr
 }
 
+func planLabels(steps []StepResult, indent string) []string {
+	var labels []string
+	for _, step := range steps {
+		labels = append(labels, indent+step.Step)
+		labels = append(labels, planLabels(step.Steps, indent+"  ")...)
+	}
+	return labels
+}
+
 func stepLabel(step TaskStep) string {
 	switch step.Type {
 	case "file":
 		return fmt.Sprintf("file:%s", step.Remote)
 	case "exec":
 		return fmt.Sprintf("exec:%s", step.Run)
+	case "script":
+		return fmt.Sprintf("script:%s", step.Local)
+	case "fetch":
+		return fmt.Sprintf("fetch:%s", step.Remote)
+	case "wait":
+		return fmt.Sprintf("wait:%s", step.waitTarget())
+	case "reboot":
+		return "reboot"
+	case "task":
+		return fmt.Sprintf("task:%s", step.Task)
 	default:
 		return step.Type
 	}
 }
+
+func newRunID() (string, error) {
+	suffix := make([]byte, 2)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", fmt.Errorf("generating run ID: %w", err)
+	}
+	return time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(suffix), nil
+}
@@ -0,0 +1,88 @@
+package vm
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const defaultScriptDir = "${TMPDIR:-/tmp}"
+
+func executeScriptStep(client *SSHClient, transfer *SFTPTransfer, step TaskStep, server ServerConfig, normalize NormalizeOptions) StepResult {
+	sr := StepResult{Step: stepLabel(step)}
+
+	script, report, err := NormalizeFileWith(step.Local, normalize)
+	if err != nil {
+		sr.Status = "error"
+		sr.Error = fmt.Sprintf("normalization failed: %v", err)
+		return sr
+	}
+	sr.Normalize = report.String()
+
+	dir, err := client.Execute(mktempCommand(server.ScriptDir))
+	if err != nil {
+		sr.Status = "error"
+		sr.Error = fmt.Sprintf("creating script directory failed: %v", err)
+		return sr
+	}
+	dir = strings.TrimSpace(dir)
+	if !path.IsAbs(dir) {
+		sr.Status = "error"
+		sr.Error = fmt.Sprintf("creating script directory failed: unexpected mktemp output %q", dir)
+		return sr
+	}
+	defer client.Execute("rm -rf " + shellQuote(dir))
+
+	remote := path.Join(dir, filepath.Base(step.Local))
+	if err := transfer.UploadBytes(script, remote); err != nil {
+		sr.Status = "error"
+		sr.Error = fmt.Sprintf("upload failed: %v", err)
+		return sr
+	}
+
+	if err := transfer.Chmod(remote, 0700); err != nil {
+		sr.Status = "error"
+		sr.Error = fmt.Sprintf("upload failed: %v", err)
+		return sr
+	}
+
+	cmd := scriptCommand(remote, step.Interpreter, step.Args)
+
+	var output string
+	if step.Sudo {
+		output, err = server.executeSudo(client, cmd)
+	} else {
+		output, err = client.Execute(cmd)
+	}
+	sr.Output = server.redact(output)
+
+	if err != nil {
+		sr.Status = "error"
+		sr.Error = server.redact(fmt.Sprintf("script failed: %v", err))
+		return sr
+	}
+
+	sr.Status = "ok"
+	return sr
+}
+
+// mktempCommand creates a private (0700) directory for one script run under
+// dir, or under $TMPDIR (default /tmp) on the host if dir is empty.
+func mktempCommand(dir string) string {
+	if dir == "" {
+		return `mktemp -d "` + defaultScriptDir + `/onevm-script.XXXXXX"`
+	}
+	return "mktemp -d " + shellQuote(path.Join(dir, "onevm-script.XXXXXX"))
+}
+
+func scriptCommand(remote, interpreter string, args []string) string {
+	parts := []string{shellQuote(remote)}
+	if interpreter != "" {
+		parts = append([]string{interpreter}, parts...)
+	}
+	for _, arg := range args {
+		parts = append(parts, shellQuote(arg))
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,62 @@
+package vm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScriptCommand(t *testing.T) {
+	tests := []struct {
+		name        string
+		interpreter string
+		args        []string
+		want        string
+	}{
+		{name: "direct", want: "'/tmp/s.sh'"},
+		{name: "interpreter", interpreter: "bash -e", want: "bash -e '/tmp/s.sh'"},
+		{name: "args quoted", args: []string{"--env", "it's prod"}, want: `'/tmp/s.sh' '--env' 'it'"'"'s prod'`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := scriptCommand("/tmp/s.sh", tt.interpreter, tt.args); got != tt.want {
+				t.Errorf("scriptCommand() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScriptStepParamsAndValidation(t *testing.T) {
+	step := expandParams(TaskStep{Type: "script", Local: "./migrate.sh", Args: []string{"{{env}}"}}, map[string]string{"env": "prod"})
+	if step.Args[0] != "prod" {
+		t.Errorf("args not expanded: %v", step.Args)
+	}
+
+	cfg := &ClientConfig{
+		Hosts: map[string]ServerConfig{"prod": {Host: "h", User: "u", Key: "k", ScriptDir: "var/tmp"}},
+		Tasks: map[string][]TaskStep{"t": {{Type: "script", Args: []string{"x"}, Fixes: []string{"nope"}, Validate: "sh -n %s"}}},
+	}
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for _, want := range []string{
+		"tasks.t[0].local: missing local script path",
+		"tasks.t[0].fixes: unknown normalize fix",
+		"tasks.t[0].validate: validate is only supported on file steps",
+		"hosts.prod.script_dir",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate() error %q does not contain %q", err, want)
+		}
+	}
+}
+
+func TestMktempCommand(t *testing.T) {
+	if got := mktempCommand(""); got != `mktemp -d "${TMPDIR:-/tmp}/onevm-script.XXXXXX"` {
+		t.Errorf("mktempCommand() = %s", got)
+	}
+	if got := mktempCommand("/var/lib/onevm"); got != "mktemp -d '/var/lib/onevm/onevm-script.XXXXXX'" {
+		t.Errorf("mktempCommand(dir) = %s", got)
+	}
+}
@@ -0,0 +1,128 @@
+package vm
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+const redacted = "********"
+
+func isSecretRef(s string) bool {
+	for _, prefix := range []string{"env:", "file:", "cmd:"} {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func ResolveSecret(ref string) (string, error) {
+	switch {
+	case strings.HasPrefix(ref, "env:"):
+		name := strings.TrimPrefix(ref, "env:")
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("secret %s: environment variable not set", ref)
+		}
+		return value, nil
+	case strings.HasPrefix(ref, "file:"):
+		data, err := os.ReadFile(ExpandHome(strings.TrimPrefix(ref, "file:")))
+		if err != nil {
+			return "", fmt.Errorf("secret %s: %w", ref, err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case strings.HasPrefix(ref, "cmd:"):
+		var stdout, stderr bytes.Buffer
+		cmd := secretCommand(strings.TrimPrefix(ref, "cmd:"))
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return "", fmt.Errorf("secret %s: %w: %s", ref, err, msg)
+			}
+			return "", fmt.Errorf("secret %s: %w", ref, err)
+		}
+		value, _, _ := strings.Cut(stdout.String(), "\n")
+		return strings.TrimRight(value, "\r"), nil
+	default:
+		return ref, nil
+	}
+}
+
+func secretCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("sh", "-c", command)
+}
+
+var secretCache = struct {
+	sync.Mutex
+	values map[string]string
+}{values: map[string]string{}}
+
+func resolveSecretField(name, ref string) (string, error) {
+	if !isSecretRef(ref) {
+		return ref, nil
+	}
+
+	secretCache.Lock()
+	defer secretCache.Unlock()
+	if value, ok := secretCache.values[ref]; ok {
+		return value, nil
+	}
+	value, err := ResolveSecret(ref)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", name, err)
+	}
+	secretCache.values[ref] = value
+	return value, nil
+}
+
+func (s ServerConfig) sshAuth() (SSHAuth, error) {
+	password, err := resolveSecretField("password", s.Password)
+	if err != nil {
+		return SSHAuth{}, err
+	}
+	passphrase, err := resolveSecretField("passphrase", s.Passphrase)
+	if err != nil {
+		return SSHAuth{}, err
+	}
+	return SSHAuth{KeyPath: ExpandHome(s.Key), Password: password, Passphrase: passphrase}, nil
+}
+
+func (s ServerConfig) connect() (*SSHClient, error) {
+	auth, err := s.sshAuth()
+	if err != nil {
+		return nil, err
+	}
+	return NewSSHClient(s.Host, s.User, auth)
+}
+
+func (s ServerConfig) executeSudo(client *SSHClient, cmd string) (string, error) {
+	password, err := resolveSecretField("sudo_password", s.SudoPassword)
+	if err != nil {
+		return "", err
+	}
+	return client.ExecuteSudo(cmd, password)
+}
+
+func (s ServerConfig) redact(text string) string {
+	for _, secret := range []string{s.Password, s.Passphrase, s.SudoPassword} {
+		if isSecretRef(secret) {
+			secretCache.Lock()
+			secret = secretCache.values[secret]
+			secretCache.Unlock()
+		}
+		if secret != "" {
+			text = strings.ReplaceAll(text, secret, redacted)
+		}
+	}
+	return text
+}
@@ -0,0 +1,107 @@
+package vm
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveSecret(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "secret")
+	os.WriteFile(secretFile, []byte("from-file\n"), 0600)
+	t.Setenv("ONEVM_TEST_SECRET", "from-env")
+
+	tests := []struct {
+		name    string
+		ref     string
+		want    string
+		wantErr bool
+	}{
+		{name: "literal", ref: "plain", want: "plain"},
+		{name: "env", ref: "env:ONEVM_TEST_SECRET", want: "from-env"},
+		{name: "env missing", ref: "env:ONEVM_TEST_MISSING", wantErr: true},
+		{name: "file", ref: "file:" + secretFile, want: "from-file"},
+		{name: "file missing", ref: "file:" + filepath.Join(dir, "nope"), wantErr: true},
+		{name: "cmd first line", ref: "cmd:printf 'from-cmd\\nmetadata\\n'", want: "from-cmd"},
+		{name: "cmd failure", ref: "cmd:exit 3", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveSecret(tt.ref)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ResolveSecret() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSecretsResolvedLazily(t *testing.T) {
+	t.Setenv("ONEVM_TEST_PASS", "hunter2")
+	marker := filepath.Join(t.TempDir(), "ran")
+	cfg := &ClientConfig{Hosts: map[string]ServerConfig{
+		"prod":   {Host: "h", User: "u", Password: "env:ONEVM_TEST_PASS", Passphrase: "cmd:touch " + marker},
+		"broken": {Host: "h", User: "u", Password: "cmd:printf '%s%s' hun ter2; exit 1"},
+	}}
+
+	host, err := cfg.ResolveHost("prod")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if host.Password != "env:ONEVM_TEST_PASS" {
+		t.Errorf("ResolveHost resolved secrets: %+v", host)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Fatal("ResolveHost ran a cmd: secret")
+	}
+
+	auth, err := host.sshAuth()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if auth.Password != "hunter2" {
+		t.Errorf("password not resolved: %+v", auth)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Error("sshAuth did not run the cmd: secret")
+	}
+
+	broken, err := cfg.ResolveHost("broken")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = broken.sshAuth()
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if strings.Contains(err.Error(), "hunter2") {
+		t.Errorf("error echoes command output: %v", err)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	server := ServerConfig{Password: "hunter2", SudoPassword: "s3cret"}
+	got := server.redact("login hunter2 then s3cret")
+	if got != "login ******** then ********" {
+		t.Errorf("redact() = %q", got)
+	}
+}
+
+func TestRedactResolvedRef(t *testing.T) {
+	t.Setenv("ONEVM_TEST_SUDO", "s3cret")
+	server := ServerConfig{SudoPassword: "env:ONEVM_TEST_SUDO"}
+	if got := server.redact("pw s3cret"); got != "pw s3cret" {
+		t.Errorf("redact() before resolving = %q", got)
+	}
+	if _, err := resolveSecretField("sudo_password", server.SudoPassword); err != nil {
+		t.Fatal(err)
+	}
+	if got := server.redact("pw s3cret"); got != "pw ********" {
+		t.Errorf("redact() = %q", got)
+	}
+}
@@ -1,13 +1,18 @@
 package vm
 
 import (
+	"context"
 	"fmt"
+	"net"
 	"os"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 )
 
+const sshDialTimeout = 30 * time.Second
+
 type SSHClient struct {
 	Client *ssh.Client
 	Host   string
@@ -15,8 +20,9 @@ type SSHClient struct {
 }
 
 type SSHAuth struct {
-	KeyPath  string
-	Password string
+	KeyPath    string
+	Password   string
+	Passphrase string
 }
 
 func NewSSHClient(host, user string, auth SSHAuth) (*SSHClient, error) {
@@ -27,7 +33,12 @@ func NewSSHClient(host, user string, auth SSHAuth) (*SSHClient, error) {
 		if err != nil {
 			return nil, fmt.Errorf("reading SSH key %s: %w", auth.KeyPath, err)
 		}
-		signer, err := ssh.ParsePrivateKey(keyData)
+		var signer ssh.Signer
+		if auth.Passphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(keyData, []byte(auth.Passphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(keyData)
+		}
 		if err != nil {
 			return nil, fmt.Errorf("parsing SSH key: %w", err)
 		}
@@ -46,6 +57,7 @@ func NewSSHClient(host, user string, auth SSHAuth) (*SSHClient, error) {
 		User:            user,
 		Auth:            methods,
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         sshDialTimeout,
 	}
 
 	addr := host
@@ -80,6 +92,86 @@ func (c *SSHClient) Execute(cmd string) (string, error) {
 	return strings.TrimSpace(string(output)), nil
 }
 
+func (c *SSHClient) ExecuteTimeout(cmd string, timeout time.Duration) (string, error) {
+	session, err := c.Client.NewSession()
+	if err != nil {
+		return "", fmt.Errorf("creating session: %w", err)
+	}
+	defer session.Close()
+
+	type result struct {
+		output []byte
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		output, err := session.CombinedOutput(cmd)
+		done <- result{output, err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		if r.err != nil {
+			return string(r.output), fmt.Errorf("executing %q: %w", cmd, r.err)
+		}
+		return strings.TrimSpace(string(r.output)), nil
+	case <-timer.C:
+		session.Close()
+		return "", fmt.Errorf("executing %q: timed out after %s", cmd, timeout)
+	}
+}
+
+func (c *SSHClient) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := c.Client.Dial(network, addr)
+		done <- result{conn, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.conn, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-done; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+func (c *SSHClient) ExecuteSudo(cmd, password string) (string, error) {
+	session, err := c.Client.NewSession()
+	if err != nil {
+		return "", fmt.Errorf("creating session: %w", err)
+	}
+	defer session.Close()
+
+	wrapped := "sudo -n sh -c " + shellQuote(cmd)
+	if password != "" {
+		wrapped = "sudo -S -p '' sh -c " + shellQuote(cmd)
+		session.Stdin = strings.NewReader(password + "\n")
+	}
+
+	output, err := session.CombinedOutput(wrapped)
+	if err != nil {
+		return string(output), fmt.Errorf("executing %q with sudo: %w", cmd, err)
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
 func (c *SSHClient) Close() error {
 	return c.Client.Close()
 }
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
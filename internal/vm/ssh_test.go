@@ -0,0 +1,21 @@
+package vm
+
+import "testing"
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{input: "/etc/nginx/nginx.conf", expected: "'/etc/nginx/nginx.conf'"},
+		{input: "/tmp/a b", expected: "'/tmp/a b'"},
+		{input: "it's", expected: `'it'"'"'s'`},
+		{input: "", expected: "''"},
+	}
+
+	for _, tt := range tests {
+		if got := shellQuote(tt.input); got != tt.expected {
+			t.Errorf("shellQuote(%q) = %s, want %s", tt.input, got, tt.expected)
+		}
+	}
+}
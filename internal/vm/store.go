@@ -0,0 +1,226 @@
+package vm
+
+import (
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	objectsDirName   = "objects"
+	objectHashPlain  = "sha256:"
+	objectHashKeyed  = "hmac-sha256:"
+	objectMACContext = "onevm object id v1"
+)
+
+func newObjectHash(key secretKey) (hash.Hash, string, error) {
+	if key.empty() {
+		return sha256.New(), objectHashPlain, nil
+	}
+
+	base := key.key
+	if base == nil {
+		derived, err := deriveKey(key.passphrase, []byte(objectMACContext))
+		if err != nil {
+			return nil, "", err
+		}
+		base = derived
+	}
+	mac := hmac.New(sha256.New, base[:])
+	mac.Write([]byte(objectMACContext))
+	return hmac.New(sha256.New, mac.Sum(nil)), objectHashKeyed, nil
+}
+
+func objectHashFor(object string, key secretKey) (hash.Hash, string, error) {
+	if strings.HasPrefix(object, objectHashKeyed) {
+		if key.empty() {
+			return nil, "", fmt.Errorf("object %s: %w", object, ErrBackupKeyMissing)
+		}
+		return newObjectHash(key)
+	}
+	return sha256.New(), objectHashPlain, nil
+}
+
+func objectPath(dir, object, compression string, encrypted bool) (string, error) {
+	sum, ok := strings.CutPrefix(object, objectHashPlain)
+	if !ok && encrypted {
+		sum, ok = strings.CutPrefix(object, objectHashKeyed)
+	}
+	if !ok || len(sum) != sha256.Size*2 {
+		return "", fmt.Errorf("invalid object reference %q", object)
+	}
+	name := sum + compressionExt(compression)
+	if encrypted {
+		name += ".enc"
+	}
+	return filepath.Join(dir, objectsDirName, sum[:2], name), nil
+}
+
+func compressionExt(compression string) string {
+	switch compression {
+	case "gzip":
+		return ".gz"
+	case "zstd":
+		return ".zst"
+	default:
+		return ""
+	}
+}
+
+func storeObject(dir, compression string, key secretKey, r io.Reader) (string, int64, error) {
+	objectsDir := filepath.Join(dir, objectsDirName)
+	if err := os.MkdirAll(objectsDir, 0700); err != nil {
+		return "", 0, fmt.Errorf("creating object directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(objectsDir, ".tmp-*")
+	if err != nil {
+		return "", 0, fmt.Errorf("creating temp object: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	encrypted := !key.empty()
+	var out io.WriteCloser = nopWriteCloser{tmp}
+	if encrypted {
+		out, err = newEncryptWriter(tmp, key)
+		if err != nil {
+			return "", 0, fmt.Errorf("encrypting object: %w", err)
+		}
+	}
+
+	hash, prefix, err := newObjectHash(key)
+	if err != nil {
+		return "", 0, err
+	}
+	cw, err := compressWriter(out, compression)
+	if err != nil {
+		return "", 0, err
+	}
+
+	size, err := io.Copy(cw, io.TeeReader(r, hash))
+	if err != nil {
+		return "", 0, fmt.Errorf("writing object: %w", err)
+	}
+	if err := cw.Close(); err != nil {
+		return "", 0, fmt.Errorf("compressing object: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return "", 0, fmt.Errorf("encrypting object: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", 0, fmt.Errorf("writing object: %w", err)
+	}
+
+	object := prefix + hex.EncodeToString(hash.Sum(nil))
+	dest, err := objectPath(dir, object, compression, encrypted)
+	if err != nil {
+		return "", 0, err
+	}
+
+	if _, err := os.Stat(dest); err == nil {
+		return object, size, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+		return "", 0, fmt.Errorf("creating object directory: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		return "", 0, fmt.Errorf("storing object %s: %w", object, err)
+	}
+
+	return object, size, nil
+}
+
+func openObject(dir, object, compression string, encrypted bool, key secretKey) (io.ReadCloser, error) {
+	p, err := objectPath(dir, object, compression, encrypted)
+	if err != nil {
+		return nil, err
+	}
+
+	if encrypted && key.empty() {
+		return nil, fmt.Errorf("object %s: %w", object, ErrBackupKeyMissing)
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, fmt.Errorf("opening object %s: %w", object, err)
+	}
+
+	var r io.Reader = f
+	if encrypted {
+		r, err = newDecryptReader(f, key)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("decrypting object %s: %w", object, err)
+		}
+	}
+
+	dr, err := decompressReader(r, compression)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("decompressing object %s: %w", object, err)
+	}
+
+	return &multiCloser{Reader: dr, closers: []func() error{dr.Close, f.Close}}, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func compressWriter(w io.Writer, compression string) (io.WriteCloser, error) {
+	switch compression {
+	case "gzip":
+		return gzip.NewWriter(w), nil
+	case "zstd":
+		return zstd.NewWriter(w)
+	case "none":
+		return nopWriteCloser{w}, nil
+	default:
+		return nil, fmt.Errorf("unknown compression %q", compression)
+	}
+}
+
+type multiCloser struct {
+	io.Reader
+	closers []func() error
+}
+
+func (m *multiCloser) Close() error {
+	var first error
+	for _, c := range m.closers {
+		if err := c(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func decompressReader(r io.Reader, compression string) (io.ReadCloser, error) {
+	switch compression {
+	case "gzip":
+		return gzip.NewReader(r)
+	case "zstd":
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	case "none":
+		return io.NopCloser(r), nil
+	default:
+		return nil, fmt.Errorf("unknown compression %q", compression)
+	}
+}
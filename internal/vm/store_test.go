@@ -0,0 +1,246 @@
+package vm
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStoreObject_RoundTrip(t *testing.T) {
+	content := []byte(strings.Repeat("server_name example.com;\n", 100))
+
+	for _, compression := range []string{"gzip", "zstd", "none"} {
+		t.Run(compression, func(t *testing.T) {
+			dir := t.TempDir()
+
+			object, size, err := storeObject(dir, compression, secretKey{}, bytes.NewReader(content))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if size != int64(len(content)) {
+				t.Errorf("got size %d, want %d", size, len(content))
+			}
+			if !strings.HasPrefix(object, "sha256:") {
+				t.Errorf("got object %q, want sha256: prefix", object)
+			}
+
+			r, err := openObject(dir, object, compression, false, secretKey{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer r.Close()
+
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Error("restored content does not match original")
+			}
+		})
+	}
+}
+
+func TestStoreObject_Dedup(t *testing.T) {
+	dir := t.TempDir()
+
+	first, _, err := storeObject(dir, "gzip", secretKey{}, strings.NewReader("same content"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second, _, err := storeObject(dir, "gzip", secretKey{}, strings.NewReader("same content"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if first != second {
+		t.Errorf("got different objects %q and %q for identical content", first, second)
+	}
+
+	var objects int
+	filepath.Walk(filepath.Join(dir, objectsDirName), func(p string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			objects++
+		}
+		return nil
+	})
+	if objects != 1 {
+		t.Errorf("got %d stored objects, want 1", objects)
+	}
+}
+
+func TestStoreObject_EncryptedAddress(t *testing.T) {
+	dir := t.TempDir()
+	var oldRaw, newRaw [secretKeyBytes]byte
+	newRaw[0] = 1
+	oldKey, newKey := secretKey{key: &oldRaw}, secretKey{key: &newRaw}
+
+	first, _, err := storeObject(dir, "zstd", oldKey, strings.NewReader("same content"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(first, "hmac-sha256:") {
+		t.Errorf("got object %q, want hmac-sha256: prefix", first)
+	}
+	plain, _, err := storeObject(t.TempDir(), "zstd", secretKey{}, strings.NewReader("same content"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.TrimPrefix(first, "hmac-sha256:") == strings.TrimPrefix(plain, "sha256:") {
+		t.Error("encrypted object address reveals the plaintext hash")
+	}
+
+	second, _, err := storeObject(dir, "zstd", newKey, strings.NewReader("same content"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if first == second {
+		t.Fatal("object stored under a rotated key reuses the old key's object")
+	}
+
+	r, err := openObject(dir, second, "zstd", true, newKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer r.Close()
+	got, err := io.ReadAll(r)
+	if err != nil || string(got) != "same content" {
+		t.Errorf("got %q, %v", got, err)
+	}
+}
+
+func TestOpenBackup(t *testing.T) {
+	dir := t.TempDir()
+	cfg := BackupConfig{Dir: dir}
+
+	t.Run("metadata pointer", func(t *testing.T) {
+		object, size, err := storeObject(dir, "gzip", secretKey{}, strings.NewReader("worker_processes 4;\n"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		backupPath := filepath.Join(dir, "10.0.0.1_etc_nginx.conf_20260205-153000.json")
+		err = writeBackupMetadata(backupPath, BackupMetadata{
+			Host:        "10.0.0.1",
+			Remote:      "/etc/nginx.conf",
+			Created:     time.Now(),
+			Object:      object,
+			Size:        size,
+			Compression: "gzip",
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		r, err := OpenBackup(cfg, backupPath)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer r.Close()
+		got, _ := io.ReadAll(r)
+		if string(got) != "worker_processes 4;\n" {
+			t.Errorf("got %q, want %q", got, "worker_processes 4;\n")
+		}
+
+		latest, err := FindLatestBackup(cfg, "10.0.0.1", "/etc/nginx.conf")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if latest != backupPath {
+			t.Errorf("got %q, want %q", latest, backupPath)
+		}
+	})
+
+	t.Run("legacy plain backup", func(t *testing.T) {
+		backupPath := filepath.Join(dir, "10.0.0.1_etc_app.conf_20260101-120000")
+		os.WriteFile(backupPath, []byte("legacy"), 0644)
+
+		r, err := OpenBackup(cfg, backupPath)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer r.Close()
+		got, _ := io.ReadAll(r)
+		if string(got) != "legacy" {
+			t.Errorf("got %q, want %q", got, "legacy")
+		}
+	})
+
+	t.Run("missing object", func(t *testing.T) {
+		backupPath := filepath.Join(dir, "10.0.0.1_etc_gone.conf_20260101-120000.json")
+		writeBackupMetadata(backupPath, BackupMetadata{
+			Host:        "10.0.0.1",
+			Remote:      "/etc/gone.conf",
+			Object:      "sha256:" + strings.Repeat("0", 64),
+			Compression: "gzip",
+		})
+
+		if _, err := OpenBackup(cfg, backupPath); err == nil {
+			t.Fatal("expected error for missing object")
+		}
+	})
+}
+
+func TestOpenBackup_Encrypted(t *testing.T) {
+	dir := t.TempDir()
+	cfg := BackupConfig{Dir: dir, Encrypt: true}
+	t.Setenv("ONEVM_BACKUP_KEY", "")
+	t.Setenv("ONEVM_BACKUP_PASSPHRASE", "correct horse battery staple")
+
+	key, err := cfg.encryptionKey()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	object, size, err := storeObject(dir, "gzip", key, strings.NewReader("DB_PASSWORD=hunter2\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	p, _ := objectPath(dir, object, "gzip", true)
+	raw, _ := os.ReadFile(p)
+	if bytes.Contains(raw, []byte("hunter2")) {
+		t.Fatal("stored object contains plaintext")
+	}
+
+	backupPath := filepath.Join(dir, "10.0.0.1_var_app_.env_20260205-153000.json")
+	writeBackupMetadata(backupPath, BackupMetadata{
+		Host:        "10.0.0.1",
+		Remote:      "/var/app/.env",
+		Object:      object,
+		Size:        size,
+		Compression: "gzip",
+		Encrypted:   true,
+	})
+
+	t.Run("decrypts with passphrase", func(t *testing.T) {
+		r, err := OpenBackup(cfg, backupPath)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer r.Close()
+		got, _ := io.ReadAll(r)
+		if string(got) != "DB_PASSWORD=hunter2\n" {
+			t.Errorf("got %q, want %q", got, "DB_PASSWORD=hunter2\n")
+		}
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		t.Setenv("ONEVM_BACKUP_PASSPHRASE", "")
+
+		_, err := OpenBackup(cfg, backupPath)
+		if !errors.Is(err, ErrBackupKeyMissing) {
+			t.Fatalf("got error %v, want ErrBackupKeyMissing", err)
+		}
+	})
+
+	t.Run("encryption enabled without key", func(t *testing.T) {
+		t.Setenv("ONEVM_BACKUP_PASSPHRASE", "")
+
+		if _, err := cfg.encryptionKey(); err == nil {
+			t.Fatal("expected error when encryption has no key")
+		}
+	})
+}
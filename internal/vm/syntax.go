@@ -0,0 +1,146 @@
+package vm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/goccy/go-yaml/parser"
+)
+
+type SyntaxError struct {
+	Format string
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("invalid %s: %s", e.Format, e.Msg)
+	}
+	return fmt.Sprintf("invalid %s at line %d, column %d: %s", e.Format, e.Line, e.Column, e.Msg)
+}
+
+func CheckSyntax(path string, data []byte) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return checkJSON(data)
+	case ".yaml", ".yml":
+		return checkYAML(data)
+	default:
+		return nil
+	}
+}
+
+func checkJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return jsonSyntaxError(data, err)
+	}
+	idx := dec.InputOffset()
+	for idx < int64(len(data)) && strings.ContainsRune(" \t\r\n", rune(data[idx])) {
+		idx++
+	}
+	if idx < int64(len(data)) {
+		line, col := offsetPosition(data, idx)
+		return &SyntaxError{Format: "JSON", Line: line, Column: col, Msg: "unexpected data after top-level value"}
+	}
+
+	return nil
+}
+
+// jsonValueOffset returns the offset of the value found by following keys
+// through nested objects, or 0 if there is none.
+func jsonValueOffset(data []byte, keys ...string) int64 {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for _, key := range keys {
+		if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+			return 0
+		}
+		for {
+			tok, err := dec.Token()
+			if err != nil {
+				return 0
+			}
+			if k, ok := tok.(string); !ok {
+				return 0
+			} else if k == key {
+				break
+			}
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return 0
+			}
+		}
+	}
+
+	idx := dec.InputOffset()
+	for idx < int64(len(data)) && strings.ContainsRune(" \t\r\n:", rune(data[idx])) {
+		idx++
+	}
+	return idx
+}
+
+func jsonSyntaxError(data []byte, err error) error {
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		line, col := offsetPosition(data, syntaxErr.Offset-1)
+		return &SyntaxError{Format: "JSON", Line: line, Column: col, Msg: syntaxErr.Error()}
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		line, col := offsetPosition(data, typeErr.Offset-1)
+		msg := fmt.Sprintf("cannot use %s as %s", typeErr.Value, typeErr.Type)
+		if typeErr.Field != "" {
+			msg = fmt.Sprintf("%s: %s", typeErr.Field, msg)
+		}
+		return &SyntaxError{Format: "JSON", Line: line, Column: col, Msg: msg}
+	}
+
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		line, col := offsetPosition(data, int64(len(data)))
+		return &SyntaxError{Format: "JSON", Line: line, Column: col, Msg: "unexpected end of input"}
+	}
+
+	return &SyntaxError{Format: "JSON", Msg: err.Error()}
+}
+
+func checkYAML(data []byte) error {
+	if _, err := parser.ParseBytes(data, 0); err != nil {
+		return yamlSyntaxError(err)
+	}
+	return nil
+}
+
+func yamlSyntaxError(err error) error {
+	var yamlErr yaml.Error
+	if errors.As(err, &yamlErr) {
+		if tk := yamlErr.GetToken(); tk != nil && tk.Position != nil {
+			return &SyntaxError{Format: "YAML", Line: tk.Position.Line, Column: tk.Position.Column, Msg: yamlErr.GetMessage()}
+		}
+		return &SyntaxError{Format: "YAML", Msg: yamlErr.GetMessage()}
+	}
+	return &SyntaxError{Format: "YAML", Msg: err.Error()}
+}
+
+func offsetPosition(data []byte, idx int64) (int, int) {
+	if idx < 0 {
+		idx = 0
+	}
+	if idx > int64(len(data)) {
+		idx = int64(len(data))
+	}
+	before := data[:idx]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := int(idx) - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
@@ -0,0 +1,49 @@
+package vm
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckSyntax(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		data     string
+		wantErr  bool
+		wantLine int
+		wantCol  int
+	}{
+		{name: "valid JSON", path: "app.json", data: `{"port": 8080}`},
+		{name: "invalid JSON", path: "app.json", data: "{\n  \"port\": 8080,\n}", wantErr: true, wantLine: 3, wantCol: 1},
+		{name: "truncated JSON", path: "app.json", data: "{\"port\": ", wantErr: true, wantLine: 1, wantCol: 10},
+		{name: "trailing data JSON", path: "app.json", data: "{}\n{}", wantErr: true, wantLine: 2, wantCol: 1},
+		{name: "valid YAML", path: "app.yaml", data: "server:\n  port: 8080\n"},
+		{name: "invalid YAML", path: "app.yml", data: "server:\n  port: 8080\n bad: x\n", wantErr: true, wantLine: 3},
+		{name: "extension case insensitive", path: "APP.JSON", data: "{", wantErr: true, wantLine: 1},
+		{name: "unknown type skipped", path: "nginx.conf", data: "{ not json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckSyntax(tt.path, []byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CheckSyntax() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				return
+			}
+
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("got %T, want *SyntaxError", err)
+			}
+			if syntaxErr.Line != tt.wantLine {
+				t.Errorf("got line %d, want %d (%v)", syntaxErr.Line, tt.wantLine, err)
+			}
+			if tt.wantCol != 0 && syntaxErr.Column != tt.wantCol {
+				t.Errorf("got column %d, want %d (%v)", syntaxErr.Column, tt.wantCol, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+var ErrHostNotAllowed = errors.New("host not allowed")
+
+func (c *ClientConfig) ResolveTargets(exprs []string) ([]string, error) {
+	var out []string
+	seen := map[string]bool{}
+
+	for _, expr := range exprs {
+		aliases, err := c.resolveTargetExpr(expr)
+		if err != nil {
+			return nil, err
+		}
+		for _, alias := range aliases {
+			if !seen[alias] {
+				seen[alias] = true
+				out = append(out, alias)
+			}
+		}
+	}
+
+	return out, nil
+}
+
+func (c *ClientConfig) TaskTargets(taskName string, targets []string) ([]string, error) {
+	task := c.TaskOptions[taskName]
+	if len(targets) == 0 {
+		targets = task.Hosts
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no targets given and task %q has no default hosts", taskName)
+	}
+
+	aliases, err := c.ResolveTargets(targets)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.checkAllowedHosts(taskName, aliases); err != nil {
+		return nil, err
+	}
+	return aliases, nil
+}
+
+func (c *ClientConfig) checkAllowedHosts(taskName string, aliases []string) error {
+	task := c.TaskOptions[taskName]
+	if len(task.AllowedHosts) == 0 {
+		return nil
+	}
+
+	allowed, err := c.ResolveTargets(task.AllowedHosts)
+	if err != nil {
+		return fmt.Errorf("task %q allowed_hosts: %w", taskName, err)
+	}
+	if refused := filterAliases(aliases, allowed, false); len(refused) > 0 {
+		return fmt.Errorf("%w: task %q refuses to run on %s (allowed_hosts: %s)",
+			ErrHostNotAllowed, taskName, strings.Join(refused, ", "), strings.Join(task.AllowedHosts, ", "))
+	}
+	return nil
+}
+
+func (c *ClientConfig) resolveTargetExpr(expr string) ([]string, error) {
+	terms := strings.Split(expr, ":")
+	if terms[0] == "" || strings.HasPrefix(terms[0], "!") || strings.HasPrefix(terms[0], "&") {
+		return nil, fmt.Errorf("invalid target %q: must start with a host, group or pattern", expr)
+	}
+
+	var result []string
+	for _, term := range terms {
+		op := byte(0)
+		if strings.HasPrefix(term, "!") || strings.HasPrefix(term, "&") {
+			op, term = term[0], term[1:]
+		}
+		if term == "" {
+			return nil, fmt.Errorf("invalid target %q: empty term", expr)
+		}
+
+		aliases, err := c.resolveTargetTerm(term, nil, op == '!')
+		if err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case '!':
+			result = filterAliases(result, aliases, false)
+		case '&':
+			result = filterAliases(result, aliases, true)
+		default:
+			result = appendUnique(result, aliases...)
+		}
+	}
+
+	return result, nil
+}
+
+// resolveTargetTerm resolves a single host, group, selector or pattern. With
+// exclude set, a selector or pattern that matches nothing resolves to no hosts
+// instead of an error, since excluding nothing is a no-op.
+func (c *ClientConfig) resolveTargetTerm(term string, stack []string, exclude bool) ([]string, error) {
+	if term == "all" {
+		return sortedKeys(c.Hosts), nil
+	}
+
+	if members, ok := c.Groups[term]; ok {
+		for i, g := range stack {
+			if g == term {
+				cycle := append(append([]string{}, stack[i:]...), term)
+				return nil, fmt.Errorf("group cycle: %s", strings.Join(cycle, " -> "))
+			}
+		}
+		var out []string
+		for _, member := range members {
+			aliases, err := c.resolveTargetTerm(member, append(stack, term), exclude)
+			if err != nil {
+				return nil, err
+			}
+			out = appendUnique(out, aliases...)
+		}
+		return out, nil
+	}
+
+	if _, ok := c.Hosts[term]; ok {
+		return []string{term}, nil
+	}
+
+	if strings.Contains(term, "=") {
+		sel, err := parseSelector(term)
+		if err != nil {
+			return nil, err
+		}
+		var out []string
+		for _, alias := range sortedKeys(c.Hosts) {
+			if sel.matches(c.Hosts[alias].Labels) {
+				out = append(out, alias)
+			}
+		}
+		if len(out) == 0 && !exclude {
+			return nil, fmt.Errorf("no hosts match selector %q", term)
+		}
+		return out, nil
+	}
+
+	if strings.ContainsAny(term, "*?[") {
+		var out []string
+		for _, alias := range sortedKeys(c.Hosts) {
+			ok, err := path.Match(term, alias)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %w", term, err)
+			}
+			if ok {
+				out = append(out, alias)
+			}
+		}
+		if len(out) == 0 && !exclude {
+			return nil, fmt.Errorf("no hosts match %q", term)
+		}
+		return out, nil
+	}
+
+	return nil, fmt.Errorf("unknown host or group: %s", term)
+}
+
+type labelRequirement struct {
+	key    string
+	value  string
+	negate bool
+}
+
+type selector []labelRequirement
+
+func parseSelector(s string) (selector, error) {
+	var sel selector
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		req := labelRequirement{}
+		key, value, ok := strings.Cut(part, "!=")
+		if ok {
+			req.negate = true
+		} else if key, value, ok = strings.Cut(part, "="); !ok {
+			return nil, fmt.Errorf("invalid selector %q: %q is not key=value or key!=value", s, part)
+		}
+		req.key, req.value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if req.key == "" {
+			return nil, fmt.Errorf("invalid selector %q: empty label name", s)
+		}
+		sel = append(sel, req)
+	}
+	return sel, nil
+}
+
+func (s selector) matches(labels map[string]string) bool {
+	for _, req := range s {
+		value, ok := labels[req.key]
+		if (ok && value == req.value) == req.negate {
+			return false
+		}
+	}
+	return true
+}
+
+func filterAliases(list, other []string, keep bool) []string {
+	set := map[string]bool{}
+	for _, a := range other {
+		set[a] = true
+	}
+	var out []string
+	for _, a := range list {
+		if set[a] == keep {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+func appendUnique(list []string, items ...string) []string {
+	for _, item := range items {
+		found := false
+		for _, existing := range list {
+			if existing == item {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, item)
+		}
+	}
+	return list
+}
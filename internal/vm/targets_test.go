@@ -0,0 +1,233 @@
+package vm
+
+import (
+	"strings"
+	"testing"
+)
+
+func targetsTestConfig() *ClientConfig {
+	host := ServerConfig{Host: "h", User: "u", Key: "k"}
+	return &ClientConfig{
+		Hosts: map[string]ServerConfig{
+			"web1": host, "web2": host, "web3": host,
+			"db1": host, "db2": host, "rc": host,
+		},
+		Groups: map[string][]string{
+			"web":   {"web3", "web1", "web2"},
+			"db":    {"db*"},
+			"prod":  {"web", "db"},
+			"loopA": {"loopB"},
+			"loopB": {"loopA"},
+		},
+	}
+}
+
+func TestResolveTargets(t *testing.T) {
+	cfg := targetsTestConfig()
+
+	tests := []struct {
+		name    string
+		targets []string
+		want    string
+		wantErr string
+	}{
+		{name: "aliases keep order", targets: []string{"rc", "web1"}, want: "rc web1"},
+		{name: "group keeps member order", targets: []string{"web"}, want: "web3 web1 web2"},
+		{name: "nested group", targets: []string{"prod"}, want: "web3 web1 web2 db1 db2"},
+		{name: "pattern", targets: []string{"web*"}, want: "web1 web2 web3"},
+		{name: "all", targets: []string{"all"}, want: "db1 db2 rc web1 web2 web3"},
+		{name: "exclude", targets: []string{"web:!web3"}, want: "web1 web2"},
+		{name: "intersect", targets: []string{"prod:&web*:!web1"}, want: "web3 web2"},
+		{name: "union in expression", targets: []string{"db:rc"}, want: "db1 db2 rc"},
+		{name: "deduplicated", targets: []string{"web1", "web", "web1"}, want: "web1 web3 web2"},
+		{name: "unknown", targets: []string{"staging"}, wantErr: "unknown host or group: staging"},
+		{name: "no match", targets: []string{"cache*"}, wantErr: `no hosts match "cache*"`},
+		{name: "cycle", targets: []string{"loopA"}, wantErr: "group cycle: loopA -> loopB -> loopA"},
+		{name: "leading exclusion", targets: []string{"!web1"}, wantErr: "invalid target"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cfg.ResolveTargets(tt.targets)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ResolveTargets() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if strings.Join(got, " ") != tt.want {
+				t.Errorf("ResolveTargets() = %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecuteRunUnresolvedTargets(t *testing.T) {
+	cfg := targetsTestConfig()
+	cfg.Tasks = map[string][]TaskStep{"t": {{Type: "exec", Run: "true"}}}
+
+	results := ExecuteRun(cfg, "t", []string{"web", "staging"}, true, Confirmation{})
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want one per target", len(results))
+	}
+	for _, r := range results {
+		if r.Status != "error" || !strings.Contains(r.Steps[0].Error, "unknown host or group: staging") {
+			t.Errorf("result = %+v", r)
+		}
+	}
+}
+
+func TestValidateGroups(t *testing.T) {
+	cfg := targetsTestConfig()
+	cfg.Tasks = map[string][]TaskStep{"t": {{Type: "exec", Run: "true"}}}
+	cfg.Groups["rc"] = []string{"web1"}
+	cfg.Groups["broken"] = []string{"web1", "nope"}
+	cfg.Hosts["all"] = ServerConfig{Host: "h", User: "u", Key: "k"}
+
+	err := cfg.Validate()
+	errs, ok := err.(ValidationErrors)
+	if !ok {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	var paths []string
+	for _, e := range errs {
+		paths = append(paths, e.Path)
+	}
+	want := "groups.broken[1] groups.loopA[0] groups.loopB[0] groups.rc hosts.all"
+	if strings.Join(paths, " ") != want {
+		t.Errorf("paths = %v, want %s", paths, want)
+	}
+}
+
+func TestResolveTargetsSelectors(t *testing.T) {
+	label := func(labels map[string]string) ServerConfig {
+		return ServerConfig{Host: "h", User: "u", Key: "k", Labels: labels}
+	}
+	cfg := &ClientConfig{
+		Hosts: map[string]ServerConfig{
+			"web1": label(map[string]string{"env": "prod", "role": "web", "region": "eu"}),
+			"web2": label(map[string]string{"env": "prod", "role": "web", "region": "us"}),
+			"db1":  label(map[string]string{"env": "prod", "role": "db"}),
+			"dev":  label(map[string]string{"env": "dev", "role": "web"}),
+			"misc": label(nil),
+		},
+		Groups: map[string][]string{"eu": {"region=eu"}},
+	}
+
+	tests := []struct {
+		target  string
+		want    string
+		wantErr bool
+	}{
+		{target: "env=prod", want: "db1 web1 web2"},
+		{target: "env=prod,role!=db", want: "web1 web2"},
+		{target: "role!=db", want: "dev misc web1 web2"},
+		{target: "env=prod, role=web, region=us", want: "web2"},
+		{target: "role=web:!env=dev", want: "web1 web2"},
+		{target: "eu", want: "web1"},
+		{target: "env=staging", wantErr: true},
+		{target: "all:!env=staging", want: "db1 dev misc web1 web2"},
+		{target: "env=prod:!nomatch*", want: "db1 web1 web2"},
+		{target: "misc:env=staging", wantErr: true},
+		{target: "env=prod,role", wantErr: true},
+		{target: "=prod", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			got, err := cfg.ResolveTargets([]string{tt.target})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveTargets(%q) error = %v, wantErr %v", tt.target, err, tt.wantErr)
+			}
+			if strings.Join(got, " ") != tt.want {
+				t.Errorf("ResolveTargets(%q) = %v, want %s", tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTaskTargets(t *testing.T) {
+	cfg := targetsTestConfig()
+	cfg.Tasks = map[string][]TaskStep{
+		"deploy":     {{Type: "exec", Run: "true"}},
+		"dev-only":   {{Type: "exec", Run: "true"}},
+		"no-default": {{Type: "exec", Run: "true"}},
+	}
+	cfg.TaskOptions = map[string]TaskOptions{
+		"deploy":   {Hosts: []string{"web"}},
+		"dev-only": {AllowedHosts: []string{"rc"}},
+	}
+
+	tests := []struct {
+		name    string
+		task    string
+		targets []string
+		want    string
+		wantErr string
+	}{
+		{name: "default hosts", task: "deploy", want: "web3 web1 web2"},
+		{name: "explicit overrides default", task: "deploy", targets: []string{"db1"}, want: "db1"},
+		{name: "no default", task: "no-default", wantErr: "has no default hosts"},
+		{name: "allowed", task: "dev-only", targets: []string{"rc"}, want: "rc"},
+		{name: "refused", task: "dev-only", targets: []string{"rc", "web1"}, wantErr: `task "dev-only" refuses to run on web1 (allowed_hosts: rc)`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cfg.TaskTargets(tt.task, tt.targets)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("TaskTargets() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if strings.Join(got, " ") != tt.want {
+				t.Errorf("TaskTargets() = %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecuteRunRefusesDisallowedHosts(t *testing.T) {
+	cfg := targetsTestConfig()
+	cfg.Tasks = map[string][]TaskStep{
+		"update-backend-dev": {{Type: "exec", Run: "true"}},
+	}
+	cfg.TaskOptions = map[string]TaskOptions{
+		"update-backend-dev": {AllowedHosts: []string{"rc"}},
+	}
+
+	results := ExecuteRun(cfg, "update-backend-dev", []string{"web1"}, true, Confirmation{})
+	if len(results) != 1 || results[0].Status != "error" {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	if !strings.Contains(results[0].Steps[0].Error, "refuses to run on web1") {
+		t.Errorf("unexpected error: %s", results[0].Steps[0].Error)
+	}
+}
+
+func TestPushAndRollbackTargets(t *testing.T) {
+	cfg := targetsTestConfig()
+
+	pushed := ExecutePush(cfg, []string{"web:!web3"}, "./f.conf", "/etc/f.conf", true, Confirmation{})
+	if len(pushed) != 2 || pushed[0].Server != "web1" || pushed[1].Server != "web2" || pushed[0].Status != "dry-run" {
+		t.Errorf("push results = %+v", pushed)
+	}
+
+	pushed = ExecutePush(cfg, []string{"staging"}, "./f.conf", "/etc/f.conf", false, Confirmation{})
+	if len(pushed) != 1 || pushed[0].Status != "error" || !strings.Contains(pushed[0].Error, "unknown host or group: staging") {
+		t.Errorf("push results = %+v", pushed)
+	}
+
+	rolled := ExecuteRollback(cfg, []string{"cache*"}, "/etc/f.conf", Confirmation{})
+	if len(rolled) != 1 || rolled[0].Status != "error" || !strings.Contains(rolled[0].Error, `no hosts match "cache*"`) {
+		t.Errorf("rollback results = %+v", rolled)
+	}
+}
@@ -4,13 +4,16 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 
 	"github.com/pkg/sftp"
 )
 
 type SFTPTransfer struct {
 	client *sftp.Client
+	ssh    *SSHClient
 }
 
 func NewSFTPTransfer(sshClient *SSHClient) (*SFTPTransfer, error) {
@@ -19,7 +22,7 @@ func NewSFTPTransfer(sshClient *SSHClient) (*SFTPTransfer, error) {
 		return nil, fmt.Errorf("creating SFTP client: %w", err)
 	}
 
-	return &SFTPTransfer{client: client}, nil
+	return &SFTPTransfer{client: client, ssh: sshClient}, nil
 }
 
 func (t *SFTPTransfer) Upload(localPath, remotePath string) error {
@@ -42,6 +45,20 @@ func (t *SFTPTransfer) Upload(localPath, remotePath string) error {
 	return nil
 }
 
+func (t *SFTPTransfer) UploadFrom(r io.Reader, remotePath string) error {
+	remote, err := t.client.Create(remotePath)
+	if err != nil {
+		return fmt.Errorf("creating remote file %s: %w", remotePath, err)
+	}
+	defer remote.Close()
+
+	if _, err = io.Copy(remote, r); err != nil {
+		return fmt.Errorf("writing to %s: %w", remotePath, err)
+	}
+
+	return nil
+}
+
 func (t *SFTPTransfer) UploadBytes(data []byte, remotePath string) error {
 	remote, err := t.client.Create(remotePath)
 	if err != nil {
@@ -81,11 +98,153 @@ func (t *SFTPTransfer) Download(remotePath, localPath string) error {
 	return nil
 }
 
+func (t *SFTPTransfer) Glob(pattern string) ([]string, error) {
+	matches, err := t.client.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("matching %s: %w", pattern, err)
+	}
+	return matches, nil
+}
+
+func (t *SFTPTransfer) IsDir(remotePath string) (bool, error) {
+	info, err := t.client.Stat(remotePath)
+	if err != nil {
+		return false, fmt.Errorf("stat %s: %w", remotePath, err)
+	}
+	return info.IsDir(), nil
+}
+
+func (t *SFTPTransfer) DownloadDir(remoteDir, localDir string) ([]string, error) {
+	var files []string
+	walker := t.client.Walk(remoteDir)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return files, fmt.Errorf("walking %s: %w", remoteDir, err)
+		}
+		if !walker.Stat().Mode().IsRegular() {
+			continue
+		}
+		rel, err := localRel(remoteDir, walker.Path())
+		if err != nil {
+			return files, err
+		}
+		dest := filepath.Join(localDir, rel)
+		if err := t.Download(walker.Path(), dest); err != nil {
+			return files, err
+		}
+		files = append(files, dest)
+	}
+	return files, nil
+}
+
+// remoteRel returns p relative to the remote directory base. Remote paths
+// always use slashes, whatever the local OS.
+func remoteRel(base, p string) (string, error) {
+	prefix := strings.TrimSuffix(path.Clean(base), "/") + "/"
+	p = path.Clean(p)
+	if !strings.HasPrefix(p, prefix) {
+		return "", fmt.Errorf("unexpected path %s under %s", p, base)
+	}
+	return strings.TrimPrefix(p, prefix), nil
+}
+
+// localRel is remoteRel in local path form. Remote names may contain
+// characters that are separators locally (\ on Windows), so a path that
+// would leave the local directory is rejected.
+func localRel(base, p string) (string, error) {
+	rel, err := remoteRel(base, p)
+	if err != nil {
+		return "", err
+	}
+	rel = filepath.FromSlash(rel)
+	if !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("remote path %s does not map to a local path under the destination", p)
+	}
+	return rel, nil
+}
+
+func (t *SFTPTransfer) Open(remotePath string) (io.ReadCloser, error) {
+	remote, err := t.client.Open(remotePath)
+	if err != nil {
+		return nil, fmt.Errorf("opening remote file %s: %w", remotePath, err)
+	}
+	return remote, nil
+}
+
+// Copy copies a file on the remote host, keeping its permissions. The copy
+// runs on the host with cp -p; only if that fails is the file streamed
+// through SFTP, which sends it down and back up again.
+func (t *SFTPTransfer) Copy(srcPath, dstPath string) error {
+	if t.ssh != nil {
+		cmd := fmt.Sprintf("mkdir -p -- %s && cp -p -- %s %s", shellQuote(path.Dir(dstPath)), shellQuote(srcPath), shellQuote(dstPath))
+		if _, err := t.ssh.Execute(cmd); err == nil {
+			return nil
+		}
+	}
+	return t.copySFTP(srcPath, dstPath)
+}
+
+func (t *SFTPTransfer) copySFTP(srcPath, dstPath string) error {
+	src, err := t.client.Open(srcPath)
+	if err != nil {
+		return fmt.Errorf("opening remote file %s: %w", srcPath, err)
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return fmt.Errorf("reading attributes of %s: %w", srcPath, err)
+	}
+
+	dir := path.Dir(dstPath)
+	if err := t.client.MkdirAll(dir); err != nil {
+		return fmt.Errorf("creating remote directory %s: %w", dir, err)
+	}
+
+	dst, err := t.client.Create(dstPath)
+	if err != nil {
+		return fmt.Errorf("creating remote file %s: %w", dstPath, err)
+	}
+	defer dst.Close()
+
+	if err := dst.Chmod(info.Mode().Perm()); err != nil {
+		return fmt.Errorf("setting permissions on %s: %w", dstPath, err)
+	}
+
+	if _, err = io.Copy(dst, src); err != nil {
+		return fmt.Errorf("copying %s to %s: %w", srcPath, dstPath, err)
+	}
+
+	return nil
+}
+
+func (t *SFTPTransfer) Size(remotePath string) (int64, error) {
+	info, err := t.client.Stat(remotePath)
+	if err != nil {
+		return 0, fmt.Errorf("stat %s: %w", remotePath, err)
+	}
+	return info.Size(), nil
+}
+
 func (t *SFTPTransfer) FileExists(remotePath string) bool {
 	_, err := t.client.Stat(remotePath)
 	return err == nil
 }
 
+func (t *SFTPTransfer) Chmod(remotePath string, mode os.FileMode) error {
+	if err := t.client.Chmod(remotePath, mode); err != nil {
+		return fmt.Errorf("chmod %s: %w", remotePath, err)
+	}
+	return nil
+}
+
+func (t *SFTPTransfer) Remove(remotePath string) error {
+	if err := t.client.Remove(remotePath); err != nil {
+		return fmt.Errorf("removing remote file %s: %w", remotePath, err)
+	}
+	return nil
+}
+
 func (t *SFTPTransfer) Close() error {
 	return t.client.Close()
 }
@@ -0,0 +1,243 @@
+package vm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/pelletier/go-toml/v2"
+)
+
+var (
+	reflectClientConfig = reflect.TypeOf(ClientConfig{})
+	reflectTask         = reflect.TypeOf(Task{})
+	reflectTaskSteps    = reflect.TypeOf([]TaskStep{})
+)
+
+type ValidationError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+	File    string `json:"file,omitempty"`
+}
+
+func (e ValidationError) Error() string {
+	msg := e.Message
+	if e.Path != "" {
+		msg = e.Path + ": " + msg
+	}
+	if e.File != "" {
+		msg += " (from " + e.File + ")"
+	}
+	return msg
+}
+
+type ValidationErrors []ValidationError
+
+func (errs ValidationErrors) Error() string {
+	if len(errs) == 1 {
+		return "config: " + errs[0].Error()
+	}
+	lines := make([]string, len(errs))
+	for i, e := range errs {
+		lines[i] = "  " + e.Error()
+	}
+	return fmt.Sprintf("config: %d problems:\n%s", len(errs), strings.Join(lines, "\n"))
+}
+
+type ValidateResult struct {
+	Config   string            `json:"config"`
+	Status   string            `json:"status"`
+	Errors   []ValidationError `json:"errors,omitempty"`
+	Warnings []LintWarning     `json:"warnings,omitempty"`
+	Error    string            `json:"error,omitempty"`
+}
+
+func ExecuteValidate(path string) ValidateResult {
+	result := ValidateResult{Config: path}
+
+	cfg, err := loadConfigTree(path, nil)
+	if err == nil {
+		err = cfg.Validate()
+	}
+
+	var verrs ValidationErrors
+	switch {
+	case err == nil:
+		result.Status = "ok"
+	case errors.As(err, &verrs):
+		result.Status = "error"
+		result.Errors = verrs
+	default:
+		result.Status = "error"
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Warnings = cfg.Lint()
+	if result.Status == "ok" && len(result.Warnings) > 0 {
+		result.Status = "warning"
+	}
+	return result
+}
+
+func sortValidationErrors(errs ValidationErrors) {
+	sort.SliceStable(errs, func(i, j int) bool {
+		if errs[i].File != errs[j].File {
+			return errs[i].File < errs[j].File
+		}
+		return lessConfigPath(errs[i].Path, errs[j].Path)
+	})
+}
+
+func lessConfigPath(a, b string) bool {
+	for a != "" && b != "" {
+		sa, ra := nextPathSegment(a)
+		sb, rb := nextPathSegment(b)
+		if sa != sb {
+			ia, errA := indexSegment(sa)
+			ib, errB := indexSegment(sb)
+			if errA == nil && errB == nil {
+				return ia < ib
+			}
+			return sa < sb
+		}
+		a, b = ra, rb
+	}
+	return len(a) < len(b)
+}
+
+func nextPathSegment(p string) (string, string) {
+	if strings.HasPrefix(p, "[") {
+		if end := strings.IndexByte(p, ']'); end >= 0 {
+			return p[:end+1], strings.TrimPrefix(p[end+1:], ".")
+		}
+	}
+	end := strings.IndexAny(p, ".[")
+	if end < 0 {
+		return p, ""
+	}
+	return p[:end], strings.TrimPrefix(p[end:], ".")
+}
+
+func indexSegment(seg string) (int, error) {
+	if !strings.HasPrefix(seg, "[") || !strings.HasSuffix(seg, "]") {
+		return 0, errors.New("not an index")
+	}
+	var i int
+	_, err := fmt.Sscanf(seg, "[%d]", &i)
+	return i, err
+}
+
+func decodeRaw(path string, data []byte) any {
+	var raw any
+	switch configFormat(path) {
+	case "YAML":
+		yaml.Unmarshal(data, &raw)
+	case "TOML":
+		var m map[string]any
+		toml.Unmarshal(data, &m)
+		raw = m
+	default:
+		json.Unmarshal(data, &raw)
+	}
+	return stringKeys(raw)
+}
+
+func unknownFields(raw any, t reflect.Type, path string) []ValidationError {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if m, ok := raw.(map[string]any); ok && t == reflectTaskSteps {
+		return unknownFields(m, reflectTask, path)
+	}
+
+	var errs []ValidationError
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := raw.(map[string]any)
+		if !ok {
+			return nil
+		}
+		for _, key := range sortedKeys(m) {
+			field, ok := jsonField(t, key)
+			fieldPath := joinConfigPath(path, key)
+			if !ok {
+				errs = append(errs, ValidationError{Path: fieldPath, Message: "unknown field"})
+				continue
+			}
+			errs = append(errs, unknownFields(m[key], field.Type, fieldPath)...)
+		}
+	case reflect.Map:
+		m, ok := raw.(map[string]any)
+		if !ok {
+			return nil
+		}
+		for _, key := range sortedKeys(m) {
+			errs = append(errs, unknownFields(m[key], t.Elem(), joinConfigPath(path, key))...)
+		}
+	case reflect.Slice:
+		items, ok := raw.([]any)
+		if !ok {
+			return nil
+		}
+		for i, item := range items {
+			errs = append(errs, unknownFields(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	}
+	return errs
+}
+
+func jsonField(t reflect.Type, key string) (reflect.StructField, bool) {
+	var fold reflect.StructField
+	found := false
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Tag.Get("json") == "" && f.Type.Kind() == reflect.Struct {
+			if inner, ok := jsonField(f.Type, key); ok {
+				return inner, true
+			}
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if name == key {
+			return f, true
+		}
+		if !found && strings.EqualFold(name, key) {
+			fold, found = f, true
+		}
+	}
+	return fold, found
+}
+
+func joinConfigPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	if key == "" {
+		return path
+	}
+	return path + "." + key
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
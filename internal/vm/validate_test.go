@@ -0,0 +1,141 @@
+package vm
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateCollectsAllErrors(t *testing.T) {
+	cfg := ClientConfig{
+		Hosts: map[string]ServerConfig{
+			"web": {Host: "h", User: ""},
+			"db":  {Host: "", User: "u", Key: "k"},
+		},
+		Tasks: map[string][]TaskStep{
+			"deploy": {
+				{Type: "exec", Run: "true"},
+				{Type: "file", Local: "a"},
+				{Type: "exec"},
+			},
+		},
+		Backup: BackupConfig{Mode: "cloud"},
+	}
+
+	err := cfg.Validate()
+	errs, ok := err.(ValidationErrors)
+	if !ok {
+		t.Fatalf("Validate() error = %T %v, want ValidationErrors", err, err)
+	}
+
+	var got []string
+	for _, e := range errs {
+		got = append(got, e.Path)
+	}
+	want := []string{
+		"backup.mode",
+		"hosts.db.host",
+		"hosts.web",
+		"hosts.web.user",
+		"tasks.deploy[1].remote",
+		"tasks.deploy[2].run",
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("paths = %v, want %v", got, want)
+	}
+	if !strings.HasPrefix(err.Error(), "config: 6 problems:\n") {
+		t.Errorf("unexpected message %q", err)
+	}
+}
+
+func TestLessConfigPath(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"tasks.a[2].run", "tasks.a[10].run", true},
+		{"tasks.a[10].run", "tasks.a[2].run", false},
+		{"hosts.web", "hosts.web.user", true},
+		{"backup.mode", "hosts.a", true},
+		{"tasks.a[1]", "tasks.b[0]", true},
+	}
+
+	for _, tt := range tests {
+		if got := lessConfigPath(tt.a, tt.b); got != tt.want {
+			t.Errorf("lessConfigPath(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestUnknownFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    []string
+	}{
+		{
+			name: "json typos",
+			file: "c.json",
+			content: `{
+				"hosts": {"web": {"host": "h", "user": "u", "key": "k", "port": 22}},
+				"tasks": {"deploy": [{"type": "file", "local": "a", "remtoe": "/b"}]},
+				"bakup": {}
+			}`,
+			want: []string{"bakup", "hosts.web.port", "tasks.deploy[0].remtoe"},
+		},
+		{
+			name:    "yaml typo",
+			file:    "c.yaml",
+			content: "hosts:\n  web: {host: h, user: u, key: k}\ntasks:\n  t:\n    - type: exec\n      command: ls\n",
+			want:    []string{"tasks.t[0].command"},
+		},
+		{
+			name:    "valid",
+			file:    "c.json",
+			content: `{"hosts": {"web": {"host": "h", "user": "u", "key": "k"}}, "tasks": {"t": [{"type": "exec", "run": "ls"}]}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, e := range unknownFields(decodeRaw(tt.file, []byte(tt.content)), reflectClientConfig, "") {
+				got = append(got, e.Path)
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("unknown fields = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecuteValidate(t *testing.T) {
+	dir := t.TempDir()
+
+	valid := filepath.Join(dir, "valid.json")
+	os.WriteFile(valid, []byte(`{"hosts": {"web": {"host": "h", "user": "u", "key": "k"}}, "tasks": {"t": {"hosts": ["web"], "steps": [{"type": "exec", "run": "ls"}]}}}`), 0644)
+
+	invalid := filepath.Join(dir, "invalid.json")
+	os.WriteFile(invalid, []byte(`{"hosts": {"web": {"host": "h", "user": "u", "key": "k", "prot": 1}}, "tasks": {"t": [{"type": "exec"}]}}`), 0644)
+
+	broken := filepath.Join(dir, "broken.json")
+	os.WriteFile(broken, []byte(`{"hosts": `), 0644)
+
+	if res := ExecuteValidate(valid); res.Status != "ok" || len(res.Errors) != 0 {
+		t.Errorf("valid config: %+v", res)
+	}
+
+	res := ExecuteValidate(invalid)
+	if res.Status != "error" || len(res.Errors) != 2 {
+		t.Fatalf("invalid config: %+v", res)
+	}
+	if res.Errors[0].Path != "hosts.web.prot" || res.Errors[1].Path != "tasks.t[0].run" {
+		t.Errorf("unexpected errors: %+v", res.Errors)
+	}
+
+	if res := ExecuteValidate(broken); res.Status != "error" || res.Error == "" {
+		t.Errorf("broken config: %+v", res)
+	}
+}
@@ -0,0 +1,154 @@
+package vm
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type BackupCheck struct {
+	Path   string `json:"path"`
+	Host   string `json:"host,omitempty"`
+	Remote string `json:"remote,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func VerifyBackups(cfg BackupConfig) ([]BackupCheck, error) {
+	dir := cfg.LocalDir()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading backup directory: %w", err)
+	}
+
+	key, err := cfg.backupKey()
+	if err != nil {
+		return nil, err
+	}
+
+	var checks []BackupCheck
+	referenced := map[string]bool{}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		backupPath := filepath.Join(dir, entry.Name())
+
+		if !isBackupMetadata(backupPath) {
+			checks = append(checks, BackupCheck{Path: backupPath, Status: "legacy"})
+			continue
+		}
+
+		meta, err := readBackupMetadata(backupPath)
+		if err != nil {
+			checks = append(checks, BackupCheck{Path: backupPath, Status: "corrupt", Error: err.Error()})
+			continue
+		}
+
+		check := BackupCheck{Path: backupPath, Host: meta.Host, Remote: meta.Remote}
+		if meta.Object == "" {
+			check.Status = "remote"
+			checks = append(checks, check)
+			continue
+		}
+		if p, err := objectPath(dir, meta.Object, meta.Compression, meta.Encrypted); err == nil {
+			referenced[p] = true
+		}
+
+		check.Status, err = verifyObject(dir, meta, key)
+		if err != nil {
+			check.Error = err.Error()
+		}
+		checks = append(checks, check)
+	}
+
+	orphans, err := findOrphanedObjects(dir, referenced)
+	if err != nil {
+		return nil, err
+	}
+	checks = append(checks, orphans...)
+
+	return checks, nil
+}
+
+func verifyObject(dir string, meta BackupMetadata, key secretKey) (string, error) {
+	p, err := objectPath(dir, meta.Object, meta.Compression, meta.Encrypted)
+	if err != nil {
+		return "corrupt", err
+	}
+	if _, err := os.Stat(p); err != nil {
+		return "missing", fmt.Errorf("object %s not found", meta.Object)
+	}
+
+	r, err := openObject(dir, meta.Object, meta.Compression, meta.Encrypted, key)
+	if err != nil {
+		if errors.Is(err, ErrBackupKeyMissing) {
+			return "skipped", err
+		}
+		return "corrupt", err
+	}
+	defer r.Close()
+
+	hash, prefix, err := objectHashFor(meta.Object, key)
+	if err != nil {
+		return "skipped", err
+	}
+	size, err := io.Copy(hash, r)
+	if err != nil {
+		return "corrupt", fmt.Errorf("reading object %s: %w", meta.Object, err)
+	}
+
+	if sum := prefix + hex.EncodeToString(hash.Sum(nil)); sum != meta.Object {
+		return "corrupt", fmt.Errorf("checksum mismatch: got %s", sum)
+	}
+	if size != meta.Size {
+		return "corrupt", fmt.Errorf("size mismatch: got %d bytes, want %d", size, meta.Size)
+	}
+
+	return "ok", nil
+}
+
+func findOrphanedObjects(dir string, referenced map[string]bool) ([]BackupCheck, error) {
+	var orphans []BackupCheck
+
+	root := filepath.Join(dir, objectsDirName)
+	err := filepath.WalkDir(root, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || referenced[p] {
+			return nil
+		}
+
+		check := BackupCheck{Path: p, Status: "orphaned"}
+		if strings.HasPrefix(d.Name(), ".tmp-") {
+			check.Error = "leftover from an interrupted backup"
+		} else {
+			check.Error = "object not referenced by any backup"
+		}
+		orphans = append(orphans, check)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scanning objects: %w", err)
+	}
+
+	sort.Slice(orphans, func(i, j int) bool {
+		return orphans[i].Path < orphans[j].Path
+	})
+
+	return orphans, nil
+}
@@ -0,0 +1,93 @@
+package vm
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestBackup(t *testing.T, dir, name, content string) (string, BackupMetadata) {
+	t.Helper()
+
+	object, size, err := storeObject(dir, "gzip", secretKey{}, strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	meta := BackupMetadata{
+		Host:        "10.0.0.1",
+		Remote:      "/etc/" + name,
+		Created:     time.Now(),
+		Object:      object,
+		Size:        size,
+		Compression: "gzip",
+	}
+	backupPath := filepath.Join(dir, "10.0.0.1_etc_"+name+"_20260205-153000.json")
+	if err := writeBackupMetadata(backupPath, meta); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return backupPath, meta
+}
+
+func TestVerifyBackups(t *testing.T) {
+	dir := t.TempDir()
+	cfg := BackupConfig{Dir: dir}
+
+	okPath, _ := writeTestBackup(t, dir, "ok.conf", "intact")
+	missingPath, missingMeta := writeTestBackup(t, dir, "missing.conf", "will be deleted")
+	corruptPath, corruptMeta := writeTestBackup(t, dir, "corrupt.conf", "will be tampered")
+
+	p, _ := objectPath(dir, missingMeta.Object, "gzip", false)
+	os.Remove(p)
+
+	p, _ = objectPath(dir, corruptMeta.Object, "gzip", false)
+	data, _ := os.ReadFile(p)
+	os.WriteFile(p, data[:len(data)/2], 0600)
+
+	brokenPath := filepath.Join(dir, "10.0.0.1_etc_broken.conf_20260205-153000.json")
+	os.WriteFile(brokenPath, []byte("{"), 0600)
+
+	legacyPath := filepath.Join(dir, "10.0.0.1_etc_old.conf_20250101-000000")
+	os.WriteFile(legacyPath, []byte("old"), 0644)
+
+	orphan, _, _ := storeObject(dir, "gzip", secretKey{}, strings.NewReader("nobody points here"))
+	orphanPath, _ := objectPath(dir, orphan, "gzip", false)
+
+	checks, err := VerifyBackups(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := map[string]string{}
+	for _, c := range checks {
+		got[c.Path] = c.Status
+	}
+
+	want := map[string]string{
+		okPath:      "ok",
+		missingPath: "missing",
+		corruptPath: "corrupt",
+		brokenPath:  "corrupt",
+		legacyPath:  "legacy",
+		orphanPath:  "orphaned",
+	}
+	for path, status := range want {
+		if got[path] != status {
+			t.Errorf("%s: got status %q, want %q", filepath.Base(path), got[path], status)
+		}
+	}
+	if len(checks) != len(want) {
+		t.Errorf("got %d checks, want %d", len(checks), len(want))
+	}
+}
+
+func TestVerifyBackups_EmptyDir(t *testing.T) {
+	checks, err := VerifyBackups(BackupConfig{Dir: filepath.Join(t.TempDir(), "missing")})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(checks) != 0 {
+		t.Errorf("got %d checks, want 0", len(checks))
+	}
+}
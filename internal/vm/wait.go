@@ -0,0 +1,176 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultWaitInterval = 2 * time.Second
+	defaultWaitTimeout  = time.Minute
+)
+
+var now = time.Now
+
+func (step TaskStep) pollInterval(fallback time.Duration) time.Duration {
+	if d, err := time.ParseDuration(step.Interval); err == nil && d > 0 {
+		return d
+	}
+	return fallback
+}
+
+func (step TaskStep) pollTimeout(fallback time.Duration) time.Duration {
+	if d, err := time.ParseDuration(step.Timeout); err == nil && d > 0 {
+		return d
+	}
+	return fallback
+}
+
+func (step TaskStep) waitTarget() string {
+	switch {
+	case step.Port != 0:
+		return fmt.Sprintf("port %d", step.Port)
+	case step.URL != "":
+		return step.URL
+	default:
+		return step.Run
+	}
+}
+
+func validateWaitStep(step TaskStep, add func(path, format string, args ...any), stepPath string) {
+	probes := 0
+	for _, set := range []bool{step.Port != 0, step.URL != "", step.Run != ""} {
+		if set {
+			probes++
+		}
+	}
+	if probes != 1 {
+		add(stepPath, "wait step needs exactly one of port, url or run")
+	}
+	if step.Port < 0 || step.Port > 65535 {
+		add(stepPath+".port", "invalid port %d", step.Port)
+	}
+	if step.URL != "" && !paramPattern.MatchString(step.URL) {
+		if u, err := url.Parse(step.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add(stepPath+".url", "invalid http(s) URL %q", step.URL)
+		}
+	}
+	switch step.From {
+	case "", "remote", "local":
+	default:
+		add(stepPath+".from", "from must be remote or local")
+	}
+	if step.From == "local" && step.Run != "" {
+		add(stepPath+".from", "command probes always run on the remote host")
+	}
+	validatePollDurations(step, add, stepPath)
+}
+
+func validatePollDurations(step TaskStep, add func(path, format string, args ...any), stepPath string) {
+	for _, f := range []struct{ key, value string }{{"interval", step.Interval}, {"timeout", step.Timeout}} {
+		if f.value == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(f.value); err != nil || d <= 0 {
+			add(stepPath+"."+f.key, "invalid duration %q", f.value)
+		}
+	}
+}
+
+func waitFor(probe func(time.Duration) error, interval, timeout time.Duration) (int, time.Duration, error) {
+	start := now()
+	for probes := 1; ; probes++ {
+		err := probe(interval)
+		elapsed := now().Sub(start)
+		if err == nil || elapsed+interval > timeout {
+			return probes, elapsed, err
+		}
+		sleep(interval)
+	}
+}
+
+func (s *runSession) waitProbe(step TaskStep) func(time.Duration) error {
+	local := step.From == "local"
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if local {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		}
+		return s.client.DialContext(ctx, network, addr)
+	}
+
+	switch {
+	case step.Port != 0:
+		host := "localhost"
+		if local {
+			host = hostOnly(s.server.Host)
+		}
+		addr := net.JoinHostPort(host, strconv.Itoa(step.Port))
+		return func(timeout time.Duration) error {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			conn, err := dial(ctx, "tcp", addr)
+			if err != nil {
+				return err
+			}
+			return conn.Close()
+		}
+	case step.URL != "":
+		client := &http.Client{
+			Transport: &http.Transport{DialContext: dial, DisableKeepAlives: true},
+		}
+		return func(timeout time.Duration) error {
+			client.Timeout = timeout
+			resp, err := client.Get(step.URL)
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			if resp.StatusCode >= 400 {
+				return fmt.Errorf("HTTP %s", resp.Status)
+			}
+			return nil
+		}
+	default:
+		return func(timeout time.Duration) error {
+			output, err := s.client.ExecuteTimeout(step.Run, timeout)
+			if err != nil {
+				if out := strings.TrimSpace(output); out != "" {
+					lines := strings.Split(out, "\n")
+					return fmt.Errorf("%v: %s", err, lines[len(lines)-1])
+				}
+				return err
+			}
+			return nil
+		}
+	}
+}
+
+func (s *runSession) executeWaitStep(step TaskStep) StepResult {
+	sr := StepResult{Step: stepLabel(step)}
+
+	probes, elapsed, err := waitFor(s.waitProbe(step), step.pollInterval(defaultWaitInterval), step.pollTimeout(defaultWaitTimeout))
+	sr.Elapsed = elapsed.Round(time.Millisecond).String()
+	if err != nil {
+		sr.Status = "error"
+		sr.Error = s.server.redact(fmt.Sprintf("%s not ready after %s (%d probes): %v", step.waitTarget(), sr.Elapsed, probes, err))
+		return sr
+	}
+
+	sr.Status = "ok"
+	sr.Output = fmt.Sprintf("%s ready after %s (%d probes)", step.waitTarget(), sr.Elapsed, probes)
+	return sr
+}
+
+func hostOnly(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
@@ -0,0 +1,133 @@
+package vm
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func stubClock(t *testing.T) {
+	t.Helper()
+	current := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	origNow, origSleep := now, sleep
+	now = func() time.Time { return current }
+	sleep = func(d time.Duration) { current = current.Add(d) }
+	t.Cleanup(func() { now, sleep = origNow, origSleep })
+}
+
+func TestWaitFor(t *testing.T) {
+	stubClock(t)
+
+	calls := 0
+	probes, elapsed, err := waitFor(func(time.Duration) error {
+		calls++
+		if calls < 3 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}, 2*time.Second, time.Minute)
+	if err != nil || probes != 3 || elapsed != 4*time.Second {
+		t.Errorf("waitFor() = %d, %s, %v, want 3, 4s, nil", probes, elapsed, err)
+	}
+
+	probes, elapsed, err = waitFor(func(time.Duration) error {
+		return errors.New("connection refused")
+	}, 2*time.Second, 5*time.Second)
+	if err == nil || err.Error() != "connection refused" {
+		t.Fatalf("expected last probe error, got %v", err)
+	}
+	if probes != 3 || elapsed != 4*time.Second {
+		t.Errorf("waitFor() = %d probes in %s, want 3 in 4s", probes, elapsed)
+	}
+}
+
+func TestWaitStepLocalProbes(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	sess := &runSession{server: ServerConfig{Host: "127.0.0.1"}}
+	sr := sess.executeWaitStep(TaskStep{Type: "wait", Port: port, From: "local", Interval: "100ms", Timeout: "1s"})
+	if sr.Status != "ok" || sr.Elapsed == "" {
+		t.Errorf("port wait = %+v", sr)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	sr = sess.executeWaitStep(TaskStep{Type: "wait", URL: srv.URL + "/health", From: "local", Interval: "100ms", Timeout: "1s"})
+	if sr.Status != "ok" {
+		t.Errorf("http wait = %+v", sr)
+	}
+
+	stubClock(t)
+	sr = sess.executeWaitStep(TaskStep{Type: "wait", URL: srv.URL + "/down", From: "local", Interval: "1s", Timeout: "3s"})
+	if sr.Status != "error" || !strings.Contains(sr.Error, "not ready after 3s (4 probes): HTTP 503 Service Unavailable") {
+		t.Errorf("http wait error = %q", sr.Error)
+	}
+}
+
+func TestWaitStepProbeTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	sess := &runSession{server: ServerConfig{Host: "127.0.0.1"}}
+	start := time.Now()
+	sr := sess.executeWaitStep(TaskStep{Type: "wait", URL: srv.URL, From: "local", Interval: "50ms", Timeout: "150ms"})
+	if sr.Status != "error" || !strings.Contains(sr.Error, "Timeout") {
+		t.Errorf("hanging endpoint = %+v", sr)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("wait took %s, probes are not bounded", elapsed)
+	}
+}
+
+func TestWaitStepValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		step TaskStep
+		want string
+	}{
+		{name: "no probe", step: TaskStep{Type: "wait"}, want: "tasks.t[0]: wait step needs exactly one of port, url or run"},
+		{name: "two probes", step: TaskStep{Type: "wait", Port: 80, Run: "true"}, want: "tasks.t[0]: wait step needs exactly one of port, url or run"},
+		{name: "bad port", step: TaskStep{Type: "wait", Port: 70000}, want: "tasks.t[0].port: invalid port 70000"},
+		{name: "bad url", step: TaskStep{Type: "wait", URL: "ftp://x"}, want: `tasks.t[0].url: invalid http(s) URL "ftp://x"`},
+		{name: "bad from", step: TaskStep{Type: "wait", Port: 80, From: "elsewhere"}, want: "tasks.t[0].from: from must be remote or local"},
+		{name: "local command", step: TaskStep{Type: "wait", Run: "true", From: "local"}, want: "tasks.t[0].from: command probes always run on the remote host"},
+		{name: "bad timeout", step: TaskStep{Type: "wait", Port: 80, Timeout: "soon"}, want: `tasks.t[0].timeout: invalid duration "soon"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &ClientConfig{
+				Hosts: map[string]ServerConfig{"prod": {Host: "h", User: "u", Key: "k"}},
+				Tasks: map[string][]TaskStep{"t": {tt.step}},
+			}
+			err := cfg.Validate()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Validate() = %v, want %q", err, tt.want)
+			}
+		})
+	}
+
+	if got := stepLabel(TaskStep{Type: "wait", Port: 8080}); got != "wait:port 8080" {
+		t.Errorf("stepLabel() = %s", got)
+	}
+}